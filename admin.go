@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	routing "github.com/go-ozzo/ozzo-routing"
+	"github.com/go-ozzo/ozzo-routing/auth"
+
+	"bob-leaderboard/app"
+	"bob-leaderboard/db"
+)
+
+// AdminAuthHandler only lets requests carrying the Api.AdminSecret bearer token through.
+// The admin endpoints are not found while no admin secret is configured.
+func AdminAuthHandler(c *routing.Context) error {
+	secret := app.Settings.Api.AdminSecret
+	if secret == "" {
+		return routing.NewHTTPError(http.StatusNotFound)
+	}
+
+	return auth.Bearer(func(c *routing.Context, token string) (auth.Identity, error) {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1 {
+			return auth.Identity("Admin"), nil
+		}
+		return nil, errors.New("invalid credential")
+	})(c)
+}
+
+// RankingDiagnosticsEndpoint returns the ranking pipeline generated for the posted
+// GetRankingsOptions, with the explain output of MongoDB for it.
+func RankingDiagnosticsEndpoint(c *routing.Context) error {
+	var options db.GetRankingsOptions
+	if err := readBody(c, &options); err != nil {
+		return err
+	}
+	if details := checkFilters(options, "filters."); len(details) > 0 {
+		return NewValidationError(details)
+	}
+
+	diagnostics, err := db.ExplainRankings(c.Request.Context(), options)
+	if err != nil {
+		requestLogger(c, "admin/diagnostics/rankings").Error("Error explaining rankings", "error", err)
+		return err
+	}
+
+	return c.Write(diagnostics)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+
+	routing "github.com/go-ozzo/ozzo-routing"
+
+	"bob-leaderboard/app/logger"
+	"bob-leaderboard/db"
+)
+
+// Error codes of the api, clients should branch on them rather than on the messages.
+const (
+	CodeBadRequest       = "bad_request"
+	CodeInvalidBody      = "invalid_body"
+	CodeValidationFailed = "validation_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodePlayerBanned     = "player_banned"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeInternal         = "internal_error"
+)
+
+// Codes of the FieldError details.
+const (
+	FieldRequired     = "required"
+	FieldInvalidType  = "invalid_type"
+	FieldInvalidValue = "invalid_value"
+)
+
+// APIError is the body of every error response. It implements routing.HTTPError so
+// fault.ErrorHandler answers with its status.
+type APIError struct {
+	Status    int          `json:"-"`
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestId string       `json:"requestId,omitempty"`
+}
+
+// FieldError is a problem with a single field of a request, named by its JSON path
+// (e.g. player.steamId) or by its query parameter.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func NewAPIError(status int, code, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+// NewValidationError returns the 400 error reporting every invalid field of a request.
+func NewValidationError(details []FieldError) *APIError {
+	message := "1 field is invalid"
+	if len(details) != 1 {
+		message = fmt.Sprintf("%d fields are invalid", len(details))
+	}
+	return &APIError{Status: http.StatusBadRequest, Code: CodeValidationFailed, Message: message, Details: details}
+}
+
+func (e *APIError) Error() string   { return e.Message }
+func (e *APIError) StatusCode() int { return e.Status }
+
+// statusCodes are the codes of the errors which only carry a status.
+var statusCodes = map[int]string{
+	http.StatusBadRequest:       CodeBadRequest,
+	http.StatusUnauthorized:     CodeUnauthorized,
+	http.StatusNotFound:         CodeNotFound,
+	http.StatusMethodNotAllowed: CodeMethodNotAllowed,
+}
+
+// convertError turns every error returned by a handler into an APIError carrying the
+// request id. Errors without a status are internal, their message is only logged.
+func convertError(c *routing.Context, err error) error {
+	var apiError *APIError
+	var httpError routing.HTTPError
+	switch {
+	case errors.As(err, &apiError):
+		converted := *apiError
+		apiError = &converted
+	case errors.Is(err, db.ErrInvalidFilter):
+		apiError = NewValidationError(filterDetails(err, "filters."))
+	case errors.Is(err, db.ErrInvalidCursor):
+		apiError = NewValidationError([]FieldError{{"cursor", FieldInvalidValue, "must be a cursor returned with a page"}})
+	case errors.As(err, &httpError):
+		code, ok := statusCodes[httpError.StatusCode()]
+		if !ok {
+			code = CodeBadRequest
+			if httpError.StatusCode() >= 500 {
+				code = CodeInternal
+			}
+		}
+		apiError = NewAPIError(httpError.StatusCode(), code, httpError.Error())
+	default:
+		apiError = NewAPIError(http.StatusInternalServerError, CodeInternal, "internal server error")
+	}
+
+	apiError.RequestId = logger.RequestID(c.Request.Context())
+	return apiError
+}
+
+// filterDetails returns the details of the db.FilterError found in err, which may join several.
+func filterDetails(err error, prefix string) []FieldError {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var details []FieldError
+		for _, err := range joined.Unwrap() {
+			details = append(details, filterDetails(err, prefix)...)
+		}
+		return details
+	}
+
+	var filterError *db.FilterError
+	if !errors.As(err, &filterError) {
+		return nil
+	}
+	return []FieldError{{prefix + filterError.Filter, FieldInvalidValue, filterError.Message}}
+}
+
+// checkFilters returns the details of the malformed filter values of the options.
+func checkFilters(options db.GetRankingsOptions, prefix string) []FieldError {
+	var details []FieldError
+	for _, problem := range options.CheckFilters() {
+		details = append(details, filterDetails(problem, prefix)...)
+	}
+	return details
+}
+
+// readBody decodes the JSON body of the request, reporting malformed bodies as 400.
+func readBody(c *routing.Context, data interface{}) error {
+	err := c.Read(data)
+	if err == nil {
+		return nil
+	}
+
+	if detail, ok := typeErrorDetail(err); ok {
+		return NewValidationError([]FieldError{detail})
+	}
+	if errors.Is(err, io.EOF) {
+		return NewAPIError(http.StatusBadRequest, CodeInvalidBody, "the request body is empty")
+	}
+	return NewAPIError(http.StatusBadRequest, CodeInvalidBody, "the request body is not valid JSON")
+}
+
+// typeErrorDetail describes a JSON value decoded into a field of another type.
+func typeErrorDetail(err error) (FieldError, bool) {
+	var typeError *json.UnmarshalTypeError
+	if !errors.As(err, &typeError) || typeError.Field == "" {
+		return FieldError{}, false
+	}
+	return FieldError{
+		typeError.Field,
+		FieldInvalidType,
+		fmt.Sprintf("must be %s, got %s", jsonTypeName(typeError.Type.Kind()), typeError.Value),
+	}, true
+}
+
+// jsonTypeName names a Go kind the way it is written in JSON, with its article for
+// the messages (e.g. "a string").
+func jsonTypeName(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return "a " + kind.String()
+}
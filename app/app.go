@@ -1,26 +1,30 @@
 package app
 
 import (
+	"os"
+
 	config "github.com/go-ozzo/ozzo-config"
-	"github.com/joho/godotenv"
 
 	"bob-leaderboard/app/logger"
 )
 
+// Config holds the raw configuration, Settings its typed and validated values.
 var Config = config.New()
 
-func Init() {
-	initConfig()
+// Init loads the configuration, then sets up the logger and the roadmap tracker.
+// The configuration isn't validated, see AppConfig.Validate.
+func Init() error {
+	if err := initConfig(); err != nil {
+		return err
+	}
 	logger.Init(Config)
+	return initTracker()
 }
 
-func initConfig() {
-	if err := Config.Load("conf/app.json"); err != nil {
-		panic(err)
-	}
-
-	err := godotenv.Load()
-	if err != nil {
-		panic("Error loading .env file")
+// Profile returns the name of the configuration profile, set with APP_PROFILE.
+func Profile() string {
+	if profile := os.Getenv("APP_PROFILE"); profile != "" {
+		return profile
 	}
+	return "default"
 }
@@ -0,0 +1,48 @@
+package app
+
+import (
+	"context"
+	"sync"
+
+	"bob-leaderboard/app/logger"
+)
+
+var background = struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}{}
+
+func init() {
+	background.ctx, background.cancel = context.WithCancel(context.Background())
+}
+
+// RunInBackground starts a long-lived worker. Its context is cancelled by
+// StopBackground, which then waits for the worker to return.
+func RunInBackground(name string, worker func(ctx context.Context)) {
+	background.wg.Add(1)
+	go func() {
+		defer background.wg.Done()
+		worker(background.ctx)
+		logger.Debug("Background worker %s stopped", name)
+	}()
+}
+
+// StopBackground cancels every background worker and waits for them to return,
+// or for ctx to be done.
+func StopBackground(ctx context.Context) error {
+	background.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		background.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
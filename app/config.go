@@ -0,0 +1,502 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	config "github.com/go-ozzo/ozzo-config"
+	"github.com/joho/godotenv"
+)
+
+// envPrefix starts the name of the environment variables overriding config values. The
+// name of the variable is the path of the value in upper case with dots replaced by
+// underscores, e.g. BOB_API_LISTENADDR for Api.ListenAddr or BOB_LOGGER_TARGETS_0_MAXLEVEL.
+const envPrefix = "BOB_"
+
+// legacyEnv are the environment variables read before the config had env overrides.
+// They still work, but the BOB_ variables take precedence.
+var legacyEnv = map[string]string{
+	"MONGO_URI":             "Mongo.URI",
+	"MONGO_DATABASE_NAME":   "Mongo.Database",
+	"API_SECRET":            "Api.Secret",
+	"LINEAR_API_KEY":        "Roadmap.Linear.ApiKey",
+	"LINEAR_WEBHOOK_SECRET": "Roadmap.Linear.WebhookSecret",
+	"GITHUB_TOKEN":          "Roadmap.GitHub.Token",
+}
+
+// AppConfig is the typed configuration of the application, decoded from Config once the
+// files and environment overrides are loaded. Values missing from the files keep the
+// defaults of DefaultConfig.
+type AppConfig struct {
+	Api struct {
+		ListenAddr string
+		Secret     string
+		// AdminSecret is the bearer token of the admin endpoints, they are disabled without it
+		AdminSecret       string
+		ReadTimeout       Duration
+		ReadHeaderTimeout Duration
+		WriteTimeout      Duration
+		IdleTimeout       Duration
+		ShutdownTimeout   Duration
+	}
+	Mongo struct {
+		URI      string
+		Database string
+		Connect  struct {
+			Attempts       int
+			InitialBackoff Duration
+			MaxBackoff     Duration
+		}
+		Timeouts struct {
+			Connect   Duration
+			Read      Duration
+			Write     Duration
+			Aggregate Duration
+			Index     Duration
+		}
+		Indexes struct {
+			SyncOnStartup bool
+			DropStale     bool
+		}
+	}
+	Migrations struct {
+		RunOnStartup bool
+	}
+	Roadmap struct {
+		Tracker string
+		Linear  struct {
+			Endpoint      string
+			ApiKey        string
+			WebhookSecret string
+			TeamId        string
+		}
+		GitHub struct {
+			Endpoint      string
+			Token         string
+			Owner         string
+			Repo          string
+			ProjectNumber int
+		}
+		File struct {
+			Path string
+		}
+	}
+	Metrics struct {
+		Enabled    bool
+		ListenAddr string
+	}
+	Live struct {
+		// TopN is how many leading positions the live updates report the shifts of, at
+		// most 99 as the top N+1 is read as a single rankings page
+		TopN int
+		// ChangeStream pushes the results stored by every instance, it needs a replica set
+		ChangeStream bool
+		Heartbeat    Duration
+	}
+	Rankings struct {
+		// Materialised serves the rankings from an in-process ranking updated on every
+		// write instead of ranking the results for each read
+		Materialised bool
+		// RebuildInterval ranks the results from scratch again, picking up the changes made
+		// by other instances and commands, 0 never does
+		RebuildInterval Duration
+	}
+	// Seasons name the periods of play results can be exported by
+	Seasons []Season
+	// Logger is configured by logger.Init from Config, its targets are free-form
+	Logger   json.RawMessage
+	SteamUrl string
+}
+
+// Settings is the typed configuration loaded by Init.
+var Settings = DefaultConfig()
+
+// DefaultConfig returns the values used for the keys missing from the config files.
+func DefaultConfig() AppConfig {
+	var c AppConfig
+
+	c.Api.ReadTimeout = Duration{15 * time.Second}
+	c.Api.ReadHeaderTimeout = Duration{5 * time.Second}
+	c.Api.WriteTimeout = Duration{30 * time.Second}
+	c.Api.IdleTimeout = Duration{120 * time.Second}
+	c.Api.ShutdownTimeout = Duration{20 * time.Second}
+
+	c.Mongo.Connect.Attempts = 5
+	c.Mongo.Connect.InitialBackoff = Duration{500 * time.Millisecond}
+	c.Mongo.Connect.MaxBackoff = Duration{10 * time.Second}
+	c.Mongo.Timeouts.Connect = Duration{10 * time.Second}
+	c.Mongo.Timeouts.Read = Duration{5 * time.Second}
+	c.Mongo.Timeouts.Write = Duration{5 * time.Second}
+	c.Mongo.Timeouts.Aggregate = Duration{15 * time.Second}
+	c.Mongo.Timeouts.Index = Duration{30 * time.Second}
+	c.Mongo.Indexes.SyncOnStartup = true
+
+	c.Roadmap.Tracker = "linear"
+	c.Roadmap.Linear.Endpoint = LinearEndpoint
+	c.Roadmap.Linear.TeamId = "BAS"
+	c.Roadmap.GitHub.Endpoint = GitHubEndpoint
+	c.Roadmap.File.Path = "conf/roadmap.yaml"
+
+	c.Metrics.ListenAddr = "127.0.0.1:9100"
+
+	c.Live.TopN = 10
+	c.Live.Heartbeat = Duration{15 * time.Second}
+
+	c.Rankings.RebuildInterval = Duration{10 * time.Minute}
+
+	return c
+}
+
+// Season is a named period of play from Start until End, an ongoing season has no End.
+type Season struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// Season returns the season with the name.
+func (c AppConfig) Season(name string) (Season, bool) {
+	for _, season := range c.Seasons {
+		if season.Name == name {
+			return season, true
+		}
+	}
+	return Season{}, false
+}
+
+// Duration is a config value written as a duration string, e.g. "500ms".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("expected a duration string such as \"5s\", got %s", data)
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// initConfig loads conf/app.json, the conf/app.<profile>.json of the profile when it
+// exists, the optional .env file and the environment overrides, then decodes Settings.
+func initConfig() error {
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("loading .env: %w", err)
+	}
+
+	files := []string{"conf/app.json"}
+	if profile := Profile(); profile != "default" {
+		profileFile := "conf/app." + profile + ".json"
+		if _, err := os.Stat(profileFile); err == nil {
+			files = append(files, profileFile)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if err := Config.Load(files...); err != nil {
+		return fmt.Errorf("loading %s: %w", strings.Join(files, ", "), err)
+	}
+
+	if err := applyEnvOverrides(); err != nil {
+		return err
+	}
+
+	settings, err := decodeConfig(Config)
+	if err != nil {
+		return err
+	}
+	Settings = settings
+
+	return nil
+}
+
+// applyEnvOverrides sets the config values which have an environment variable set.
+func applyEnvOverrides() error {
+	for name, path := range legacyEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			if err := Config.Set(path, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Every key of the typed config can be overridden, even when the files leave it out,
+	// the other keys (e.g. the logger targets) when they are in the files
+	types := configTypes(reflect.TypeOf(AppConfig{}), "")
+	for _, path := range leafPaths(Config.Data(), "") {
+		if _, ok := types[path]; !ok {
+			types[path] = reflect.TypeOf(Config.Get(path))
+		}
+	}
+
+	for path, t := range types {
+		value, ok := os.LookupEnv(envName(path))
+		if !ok {
+			continue
+		}
+
+		parsed, err := parseEnvValue(t, value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", envName(path), err)
+		}
+		if err := Config.Set(path, parsed); err != nil {
+			return fmt.Errorf("%s: %w", envName(path), err)
+		}
+	}
+	return nil
+}
+
+func envName(path string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// parseEnvValue converts the value of an environment variable to the type of the config
+// value, anything but booleans and numbers is kept as a string.
+func parseEnvValue(t reflect.Type, value string) (interface{}, error) {
+	if t == nil {
+		return value, nil
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	case reflect.Int, reflect.Int64, reflect.Float64:
+		return strconv.ParseFloat(value, 64)
+	}
+	return value, nil
+}
+
+var durationType = reflect.TypeOf(Duration{})
+
+// configTypes maps the paths of the typed config values to their type.
+func configTypes(t reflect.Type, prefix string) map[string]reflect.Type {
+	types := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		path := prefix + field.Name
+		switch {
+		case field.Type.Kind() == reflect.Struct && field.Type != durationType:
+			for path, t := range configTypes(field.Type, path+".") {
+				types[path] = t
+			}
+		case field.Type.Kind() != reflect.Slice:
+			types[path] = field.Type
+		}
+	}
+	return types
+}
+
+// leafPaths lists the paths of the values in the loaded config data, including array items.
+func leafPaths(data interface{}, prefix string) []string {
+	var paths []string
+	switch data := data.(type) {
+	case map[string]interface{}:
+		for key, value := range data {
+			paths = append(paths, leafPaths(value, prefix+key+".")...)
+		}
+	case []interface{}:
+		for i, value := range data {
+			paths = append(paths, leafPaths(value, prefix+strconv.Itoa(i)+".")...)
+		}
+	default:
+		paths = append(paths, strings.TrimSuffix(prefix, "."))
+	}
+	return paths
+}
+
+// decodeConfig decodes the config data over the defaults, rejecting the keys which
+// AppConfig doesn't know, so a typo doesn't silently leave a value unset.
+func decodeConfig(c *config.Config) (AppConfig, error) {
+	settings := DefaultConfig()
+	data := c.Data()
+
+	if unknown := unknownKeys(data, reflect.TypeOf(settings), ""); len(unknown) > 0 {
+		return settings, fmt.Errorf("unknown config keys: %s", strings.Join(unknown, ", "))
+	}
+
+	// Durations are checked first, encoding/json doesn't report the path of their errors
+	types := configTypes(reflect.TypeOf(settings), "")
+	paths := make([]string, 0, len(types))
+	for path := range types {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if types[path] != durationType {
+			continue
+		}
+		value := c.Get(path)
+		if value == nil {
+			continue
+		}
+		if s, ok := value.(string); !ok {
+			return settings, fmt.Errorf("config %s: expected a duration string such as \"5s\", got %v", path, value)
+		} else if _, err := time.ParseDuration(s); err != nil {
+			return settings, fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return settings, err
+	}
+	if err := json.Unmarshal(encoded, &settings); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return settings, fmt.Errorf("config %s: expected a %s, got a %s", typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+		return settings, fmt.Errorf("config: %w", err)
+	}
+
+	return settings, nil
+}
+
+func unknownKeys(data interface{}, t reflect.Type, prefix string) []string {
+	if items, ok := data.([]interface{}); ok && t.Kind() == reflect.Slice {
+		var unknown []string
+		for i, item := range items {
+			unknown = append(unknown, unknownKeys(item, t.Elem(), prefix+strconv.Itoa(i)+".")...)
+		}
+		return unknown
+	}
+
+	values, ok := data.(map[string]interface{})
+	if !ok || t.Kind() != reflect.Struct || t == durationType {
+		return nil
+	}
+
+	var unknown []string
+	for key, value := range values {
+		// Keys match the field names case insensitively, like encoding/json
+		field, found := t.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, key) })
+		if !found {
+			unknown = append(unknown, prefix+key)
+			continue
+		}
+		unknown = append(unknown, unknownKeys(value, field.Type, prefix+key+".")...)
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// Validate checks the values the application cannot run without, returning every problem found.
+func (c AppConfig) Validate() []error {
+	var problems []error
+	require := func(value, path, env string) {
+		if value == "" {
+			problems = append(problems, fmt.Errorf("%s is not set, set it in the config or with %s or %s", path, env, envName(path)))
+		}
+	}
+
+	require(c.Mongo.URI, "Mongo.URI", "MONGO_URI")
+	require(c.Mongo.Database, "Mongo.Database", "MONGO_DATABASE_NAME")
+	require(c.Api.Secret, "Api.Secret", "API_SECRET")
+	if c.Api.ListenAddr == "" {
+		problems = append(problems, errors.New("Api.ListenAddr is not set"))
+	}
+
+	durations := map[string]Duration{
+		"Api.ReadTimeout":              c.Api.ReadTimeout,
+		"Api.ReadHeaderTimeout":        c.Api.ReadHeaderTimeout,
+		"Api.WriteTimeout":             c.Api.WriteTimeout,
+		"Api.IdleTimeout":              c.Api.IdleTimeout,
+		"Api.ShutdownTimeout":          c.Api.ShutdownTimeout,
+		"Mongo.Connect.InitialBackoff": c.Mongo.Connect.InitialBackoff,
+		"Mongo.Connect.MaxBackoff":     c.Mongo.Connect.MaxBackoff,
+		"Mongo.Timeouts.Connect":       c.Mongo.Timeouts.Connect,
+		"Mongo.Timeouts.Read":          c.Mongo.Timeouts.Read,
+		"Mongo.Timeouts.Write":         c.Mongo.Timeouts.Write,
+		"Mongo.Timeouts.Aggregate":     c.Mongo.Timeouts.Aggregate,
+		"Mongo.Timeouts.Index":         c.Mongo.Timeouts.Index,
+		"Live.Heartbeat":               c.Live.Heartbeat,
+	}
+	paths := make([]string, 0, len(durations))
+	for path := range durations {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if durations[path].Duration <= 0 {
+			problems = append(problems, fmt.Errorf("%s must be a positive duration", path))
+		}
+	}
+
+	if c.Mongo.Connect.Attempts < 1 {
+		problems = append(problems, errors.New("Mongo.Connect.Attempts must be at least 1"))
+	}
+	if c.Rankings.RebuildInterval.Duration < 0 {
+		problems = append(problems, errors.New("Rankings.RebuildInterval must not be negative"))
+	}
+	if c.Live.TopN < 1 || c.Live.TopN > 99 {
+		problems = append(problems, errors.New("Live.TopN must be between 1 and 99"))
+	}
+	seasons := map[string]bool{}
+	for i, season := range c.Seasons {
+		switch {
+		case season.Name == "":
+			problems = append(problems, fmt.Errorf("Seasons.%d.Name is not set", i))
+		case seasons[season.Name]:
+			problems = append(problems, fmt.Errorf("Seasons.%d.Name %q is used by another season", i, season.Name))
+		}
+		seasons[season.Name] = true
+		if season.Start.IsZero() {
+			problems = append(problems, fmt.Errorf("Seasons.%d.Start is not set", i))
+		} else if !season.End.IsZero() && !season.End.After(season.Start) {
+			problems = append(problems, fmt.Errorf("Seasons.%d.End must be after its Start", i))
+		}
+	}
+	if c.Metrics.Enabled && c.Metrics.ListenAddr == c.Api.ListenAddr {
+		problems = append(problems, errors.New("Metrics.ListenAddr must differ from Api.ListenAddr to keep /metrics internal"))
+	}
+	if c.Metrics.Enabled && Profile() == "prod" && listensOnEveryInterface(c.Metrics.ListenAddr) {
+		problems = append(problems, fmt.Errorf("Metrics.ListenAddr %q must name a loopback or private host in the prod profile to keep /metrics internal", c.Metrics.ListenAddr))
+	}
+
+	switch c.Roadmap.Tracker {
+	case "linear":
+		require(c.Roadmap.Linear.ApiKey, "Roadmap.Linear.ApiKey", "LINEAR_API_KEY")
+	case "github":
+		// The token is optional: public repositories can be read without one
+		if c.Roadmap.GitHub.Owner == "" {
+			problems = append(problems, errors.New("Roadmap.GitHub.Owner is required by the github tracker"))
+		}
+		if c.Roadmap.GitHub.ProjectNumber == 0 && c.Roadmap.GitHub.Repo == "" {
+			problems = append(problems, errors.New("Roadmap.GitHub.Repo is required by the github tracker when no ProjectNumber is set"))
+		}
+	case "file":
+		if _, err := os.Stat(c.Roadmap.File.Path); err != nil {
+			problems = append(problems, fmt.Errorf("Roadmap.File.Path: %w", err))
+		}
+	default:
+		problems = append(problems, fmt.Errorf("unknown Roadmap.Tracker %q, expected linear, github or file", c.Roadmap.Tracker))
+	}
+
+	return problems
+}
+
+// listensOnEveryInterface reports whether a listen address leaves its host out or names
+// an unspecified address, like ":9100" or "0.0.0.0:9100".
+func listensOnEveryInterface(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
@@ -0,0 +1,272 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	config "github.com/go-ozzo/ozzo-config"
+)
+
+func TestValidateMetricsListenAddr(t *testing.T) {
+	tests := []struct {
+		profile    string
+		enabled    bool
+		listenAddr string
+		rejected   bool
+	}{
+		{"prod", true, ":9100", true},
+		{"prod", true, "0.0.0.0:9100", true},
+		{"prod", true, "[::]:9100", true},
+		{"prod", true, "9100", true},
+		{"prod", true, "127.0.0.1:9100", false},
+		{"prod", true, "10.0.3.7:9100", false},
+		{"prod", true, "localhost:9100", false},
+		{"prod", false, ":9100", false},
+		{"default", true, ":9100", false},
+	}
+
+	for _, test := range tests {
+		t.Setenv("APP_PROFILE", test.profile)
+		config := DefaultConfig()
+		config.Metrics.Enabled = test.enabled
+		config.Metrics.ListenAddr = test.listenAddr
+
+		rejected := false
+		for _, problem := range config.Validate() {
+			rejected = rejected || strings.HasPrefix(problem.Error(), "Metrics.ListenAddr")
+		}
+		if rejected != test.rejected {
+			t.Errorf("%s profile, enabled %v, %q: rejected = %v, want %v", test.profile, test.enabled, test.listenAddr, rejected, test.rejected)
+		}
+	}
+}
+
+// loadConfig runs initConfig in a directory holding the config files, with the
+// environment variables set, restoring the loaded config when the test ends.
+func loadConfig(t *testing.T, files map[string]string, env map[string]string) (AppConfig, error) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "conf"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, "conf", name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	loaded, settings := Config, Settings
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		Config, Settings = loaded, settings
+	})
+
+	// The variables of the machine running the tests must not leak into the config
+	for name := range legacyEnv {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	t.Setenv("APP_PROFILE", "")
+	for name, value := range env {
+		t.Setenv(name, value)
+	}
+
+	Config = config.New()
+	err = initConfig()
+	return Settings, err
+}
+
+func TestInitConfigProfiles(t *testing.T) {
+	files := map[string]string{
+		"app.json": `{
+			"Api": {"ListenAddr": ":6969", "Secret": "base", "ReadTimeout": "10s"},
+			"Mongo": {"Database": "bob", "Connect": {"Attempts": 3}}
+		}`,
+		"app.prod.json": `{
+			"Api": {"Secret": "prod"},
+			"Mongo": {"Connect": {"MaxBackoff": "1m"}}
+		}`,
+	}
+
+	tests := []struct {
+		profile    string
+		secret     string
+		maxBackoff time.Duration
+	}{
+		{"default", "base", 10 * time.Second},
+		{"prod", "prod", time.Minute},
+		// A profile without a file of its own only reads app.json
+		{"dev", "base", 10 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run("profile "+test.profile, func(t *testing.T) {
+			settings, err := loadConfig(t, files, map[string]string{"APP_PROFILE": test.profile})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if settings.Api.Secret != test.secret || settings.Mongo.Connect.MaxBackoff.Duration != test.maxBackoff {
+				t.Errorf("Secret %q, MaxBackoff %v, want %q, %v", settings.Api.Secret, settings.Mongo.Connect.MaxBackoff, test.secret, test.maxBackoff)
+			}
+			// The keys the profile leaves out keep the values of app.json, or the defaults
+			if settings.Api.ListenAddr != ":6969" || settings.Api.ReadTimeout.Duration != 10*time.Second ||
+				settings.Mongo.Database != "bob" || settings.Mongo.Connect.Attempts != 3 ||
+				settings.Api.WriteTimeout.Duration != 30*time.Second {
+				t.Errorf("the merged config is %+v", settings.Api)
+			}
+		})
+	}
+}
+
+func TestInitConfigEnvOverrides(t *testing.T) {
+	files := map[string]string{
+		"app.json": `{
+			"Api": {"ListenAddr": ":6969", "Secret": "file"},
+			"Mongo": {"URI": "mongodb://file"},
+			"Logger": {"Targets": [{"MaxLevel": 4}]}
+		}`,
+	}
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		check func(AppConfig) bool
+	}{
+		{"legacy", map[string]string{"MONGO_URI": "mongodb://legacy", "API_SECRET": "legacy"},
+			func(c AppConfig) bool { return c.Mongo.URI == "mongodb://legacy" && c.Api.Secret == "legacy" }},
+		{"prefixed", map[string]string{"BOB_MONGO_URI": "mongodb://bob"},
+			func(c AppConfig) bool { return c.Mongo.URI == "mongodb://bob" && c.Api.Secret == "file" }},
+		{"prefixed over legacy", map[string]string{"MONGO_URI": "mongodb://legacy", "BOB_MONGO_URI": "mongodb://bob"},
+			func(c AppConfig) bool { return c.Mongo.URI == "mongodb://bob" }},
+		{"key missing from the files", map[string]string{"BOB_MONGO_CONNECT_ATTEMPTS": "7", "BOB_METRICS_ENABLED": "true"},
+			func(c AppConfig) bool { return c.Mongo.Connect.Attempts == 7 && c.Metrics.Enabled }},
+		{"duration", map[string]string{"BOB_API_READTIMEOUT": "2s"},
+			func(c AppConfig) bool { return c.Api.ReadTimeout.Duration == 2*time.Second }},
+		{"logger target", map[string]string{"BOB_LOGGER_TARGETS_0_MAXLEVEL": "2"},
+			func(c AppConfig) bool { return strings.Contains(string(c.Logger), `"MaxLevel":2`) }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			settings, err := loadConfig(t, files, test.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.check(settings) {
+				t.Errorf("the env %v was not applied", test.env)
+			}
+		})
+	}
+
+	t.Run("unparsable boolean", func(t *testing.T) {
+		_, err := loadConfig(t, files, map[string]string{"BOB_METRICS_ENABLED": "yes please"})
+		if err == nil || !strings.Contains(err.Error(), "BOB_METRICS_ENABLED") {
+			t.Errorf("error %v, want one naming BOB_METRICS_ENABLED", err)
+		}
+	})
+}
+
+func TestInitConfigRejectsBadValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		env     map[string]string
+		problem string
+	}{
+		{"unknown key", `{"Api": {"ListenAdress": ":6969"}}`, nil, "unknown config keys: Api.ListenAdress"},
+		{"unknown section", `{"Mongo": {"URI": "mongodb://file"}, "Mongoo": {}}`, nil, "unknown config keys: Mongoo"},
+		{"unknown season key", `{"Seasons": [{"Name": "1", "Begin": "2024-01-01T00:00:00Z"}]}`, nil, "unknown config keys: Seasons.0.Begin"},
+		{"bad duration", `{"Mongo": {"Timeouts": {"Read": "5 parsecs"}}}`, nil, "config Mongo.Timeouts.Read"},
+		{"number duration", `{"Api": {"WriteTimeout": 30}}`, nil, `config Api.WriteTimeout: expected a duration string such as "5s"`},
+		{"bad duration override", `{}`, map[string]string{"BOB_LIVE_HEARTBEAT": "often"}, "config Live.Heartbeat"},
+		{"wrong type", `{"Live": {"TopN": "ten"}}`, nil, "config Live.TopN: expected a int"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadConfig(t, map[string]string{"app.json": test.config}, test.env)
+			if err == nil || !strings.Contains(err.Error(), test.problem) {
+				t.Errorf("error %v, want one containing %q", err, test.problem)
+			}
+		})
+	}
+
+	// The logger targets are free-form
+	t.Run("logger", func(t *testing.T) {
+		if _, err := loadConfig(t, map[string]string{"app.json": `{"Logger": {"Anything": {"Goes": 1}}}`}, nil); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestValidateRoadmapTracker(t *testing.T) {
+	roadmapFile := filepath.Join(t.TempDir(), "roadmap.yaml")
+	if err := os.WriteFile(roadmapFile, []byte("[]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		change   func(c *AppConfig)
+		problems []string
+	}{
+		{"linear", func(c *AppConfig) { c.Roadmap.Linear.ApiKey = "key" }, nil},
+		{"linear without key", func(c *AppConfig) {}, []string{"Roadmap.Linear.ApiKey is not set"}},
+		{"github repository", func(c *AppConfig) {
+			c.Roadmap.Tracker = "github"
+			c.Roadmap.GitHub.Owner, c.Roadmap.GitHub.Repo = "bob", "game"
+		}, nil},
+		{"github project without repository", func(c *AppConfig) {
+			c.Roadmap.Tracker = "github"
+			c.Roadmap.GitHub.Owner, c.Roadmap.GitHub.ProjectNumber = "bob", 3
+		}, nil},
+		{"github without repository or project", func(c *AppConfig) {
+			c.Roadmap.Tracker = "github"
+			c.Roadmap.GitHub.Owner = "bob"
+		}, []string{"Roadmap.GitHub.Repo is required"}},
+		{"github without owner", func(c *AppConfig) {
+			c.Roadmap.Tracker = "github"
+			c.Roadmap.GitHub.ProjectNumber = 3
+		}, []string{"Roadmap.GitHub.Owner is required"}},
+		{"file", func(c *AppConfig) {
+			c.Roadmap.Tracker = "file"
+			c.Roadmap.File.Path = roadmapFile
+		}, nil},
+		{"missing file", func(c *AppConfig) {
+			c.Roadmap.Tracker = "file"
+			c.Roadmap.File.Path = roadmapFile + ".missing"
+		}, []string{"Roadmap.File.Path"}},
+		{"unknown tracker", func(c *AppConfig) { c.Roadmap.Tracker = "jira" }, []string{`unknown Roadmap.Tracker "jira"`}},
+	}
+
+	for _, test := range tests {
+		config := DefaultConfig()
+		config.Mongo.URI, config.Mongo.Database = "mongodb://localhost", "bob"
+		config.Api.Secret, config.Api.ListenAddr = "secret", ":6969"
+		test.change(&config)
+
+		var problems []string
+		for _, problem := range config.Validate() {
+			problems = append(problems, problem.Error())
+		}
+		if len(problems) != len(test.problems) {
+			t.Errorf("%s: problems %q, want %q", test.name, problems, test.problems)
+			continue
+		}
+		for i, problem := range problems {
+			if !strings.HasPrefix(problem, test.problems[i]) {
+				t.Errorf("%s: problem %q, want %q", test.name, problem, test.problems[i])
+			}
+		}
+	}
+}
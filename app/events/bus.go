@@ -0,0 +1,108 @@
+// Package events is an in-process publish/subscribe bus, used to push leaderboard
+// changes to the live clients.
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Event is a message published on a Bus.
+type Event struct {
+	Type string
+	Data interface{}
+}
+
+// Bus delivers every published event to its subscribers. Publishing never blocks: a
+// subscriber which doesn't keep up misses the events its buffer can't hold.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	closed      bool
+}
+
+func NewBus() *Bus {
+	return &Bus{subscribers: map[*Subscription]struct{}{}}
+}
+
+// Subscription receives the events published after it was created, until it or the
+// bus is closed.
+type Subscription struct {
+	bus     *Bus
+	events  chan Event
+	dropped atomic.Int64
+}
+
+// Subscribe returns a subscription buffering up to buffer events. The subscription of
+// a closed bus is closed.
+func (b *Bus) Subscribe(buffer int) *Subscription {
+	s := &Subscription{bus: b, events: make(chan Event, buffer)}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(s.events)
+		return s
+	}
+	b.subscribers[s] = struct{}{}
+	return s
+}
+
+// Publish delivers the event to every subscriber with room in its buffer, and returns
+// how many subscribers missed it.
+func (b *Bus) Publish(event Event) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	missed := 0
+	for s := range b.subscribers {
+		select {
+		case s.events <- event:
+		default:
+			s.dropped.Add(1)
+			missed++
+		}
+	}
+	return missed
+}
+
+// Subscribers returns the number of open subscriptions.
+func (b *Bus) Subscribers() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers)
+}
+
+// Close closes every subscription, so their readers stop, and the subscriptions made later.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subscribers {
+		delete(b.subscribers, s)
+		close(s.events)
+	}
+}
+
+// Events returns the channel of the events, it is closed with the subscription.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped returns how many events were missed because the buffer was full.
+func (s *Subscription) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Close stops the subscription, it can be called more than once.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+
+	if _, ok := s.bus.subscribers[s]; ok {
+		delete(s.bus.subscribers, s)
+		close(s.events)
+	}
+}
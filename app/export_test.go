@@ -0,0 +1,9 @@
+package app
+
+// ClearCachedIssues forgets the cached roadmap, so a test starts without one.
+func ClearCachedIssues() {
+	issuesMu.Lock()
+	defer issuesMu.Unlock()
+	issuesData = nil
+	roadmapVersion.Add(1)
+}
@@ -0,0 +1,285 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// GitHubEndpoint is the base url of the public GitHub API.
+const GitHubEndpoint = "https://api.github.com"
+
+// GitHubTracker loads the roadmap from GitHub. When ProjectNumber is set the items of
+// that GitHub Project (v2) are used and grouped by their "Status" field, otherwise the
+// issues of Owner/Repo are used and grouped by labels matching one of StateOrder.
+type GitHubTracker struct {
+	Endpoint      string
+	Token         string
+	Owner         string
+	Repo          string
+	ProjectNumber int
+}
+
+type gitHubLabel struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+type gitHubIssue struct {
+	Number      int           `json:"number"`
+	Title       string        `json:"title"`
+	State       string        `json:"state"`
+	StateReason string        `json:"state_reason"`
+	Labels      []gitHubLabel `json:"labels"`
+	ClosedAt    *time.Time    `json:"closed_at"`
+	PullRequest *struct{}     `json:"pull_request"`
+}
+
+type gitHubProjectResponse struct {
+	Data struct {
+		RepositoryOwner struct {
+			ProjectV2 struct {
+				Items struct {
+					PageInfo struct {
+						HasNextPage bool   `json:"hasNextPage"`
+						EndCursor   string `json:"endCursor"`
+					} `json:"pageInfo"`
+					Nodes []struct {
+						Status struct {
+							Name string `json:"name"`
+						} `json:"status"`
+						Content struct {
+							Number   int        `json:"number"`
+							Title    string     `json:"title"`
+							ClosedAt *time.Time `json:"closedAt"`
+							Labels   struct {
+								Nodes []gitHubLabel `json:"nodes"`
+							} `json:"labels"`
+						} `json:"content"`
+					} `json:"nodes"`
+				} `json:"items"`
+			} `json:"projectV2"`
+		} `json:"repositoryOwner"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (t *GitHubTracker) Name() string { return "github" }
+
+func (t *GitHubTracker) LoadIssues(ctx context.Context) (OrganizedIssues, error) {
+	if t.Owner == "" {
+		return nil, fmt.Errorf("github tracker requires Roadmap.GitHub.Owner")
+	}
+
+	var issues []Issue
+	var err error
+	if t.ProjectNumber > 0 {
+		issues, err = t.loadProjectItems(ctx)
+	} else {
+		issues, err = t.loadRepoIssues(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return organizeIssues(issues), nil
+}
+
+func (t *GitHubTracker) loadRepoIssues(ctx context.Context) ([]Issue, error) {
+	if t.Repo == "" {
+		return nil, fmt.Errorf("github tracker requires Roadmap.GitHub.Repo when no project is configured")
+	}
+
+	var issues []Issue
+	for page := 1; ; page++ {
+		url := fmt.Sprintf("%s/repos/%s/%s/issues?state=all&per_page=100&page=%d", t.Endpoint, t.Owner, t.Repo, page)
+
+		var items []gitHubIssue
+		if err := t.do(ctx, "GET", url, nil, &items); err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			// The issues api also lists pull requests, they don't belong on the roadmap
+			if item.PullRequest != nil {
+				continue
+			}
+			issues = append(issues, item.toIssue())
+		}
+
+		if len(items) < 100 {
+			break
+		}
+	}
+
+	return issues, nil
+}
+
+func (t *GitHubTracker) loadProjectItems(ctx context.Context) ([]Issue, error) {
+	query := `query($owner: String!, $number: Int!, $after: String) {
+		repositoryOwner(login: $owner) {
+			... on ProjectV2Owner {
+				projectV2(number: $number) {
+					items(first: 100, after: $after) {
+						pageInfo { hasNextPage endCursor }
+						nodes {
+							status: fieldValueByName(name: "Status") {
+								... on ProjectV2ItemFieldSingleSelectValue { name }
+							}
+							content {
+								... on Issue {
+									number
+									title
+									closedAt
+									labels(first: 20) { nodes { name color } }
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}`
+
+	var issues []Issue
+	var after interface{} = nil
+	for {
+		payload := GraphQLRequest{
+			Query: query,
+			Variables: map[string]interface{}{
+				"owner":  t.Owner,
+				"number": t.ProjectNumber,
+				"after":  after,
+			},
+		}
+
+		var response gitHubProjectResponse
+		if err := t.do(ctx, "POST", t.Endpoint+"/graphql", payload, &response); err != nil {
+			return nil, err
+		}
+		if len(response.Errors) > 0 {
+			return nil, fmt.Errorf("github graphql error: %s", response.Errors[0].Message)
+		}
+
+		items := response.Data.RepositoryOwner.ProjectV2.Items
+		for _, node := range items.Nodes {
+			// Draft issues and pull requests have no issue number
+			if node.Content.Number == 0 {
+				continue
+			}
+
+			issues = append(issues, Issue{
+				Identifier:  fmt.Sprintf("#%d", node.Content.Number),
+				Title:       node.Content.Title,
+				State:       State{Name: node.Status.Name},
+				Labels:      convertGitHubLabels(node.Content.Labels.Nodes),
+				CompletedAt: node.Content.ClosedAt,
+			})
+		}
+
+		if !items.PageInfo.HasNextPage {
+			break
+		}
+		after = items.PageInfo.EndCursor
+	}
+
+	return issues, nil
+}
+
+func (t *GitHubTracker) do(ctx context.Context, method, url string, payload any, out any) error {
+	var body io.Reader
+	if payload != nil {
+		payloadBytes, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewBuffer(payloadBytes)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Content-Type", "application/json")
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("github responded with status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// toIssue maps a repository issue onto the roadmap states: closed issues are Done
+// (or Canceled when closed as not planned), open issues use the first label that
+// names a state and fall back to Todo.
+func (i gitHubIssue) toIssue() Issue {
+	issue := Issue{
+		Identifier: fmt.Sprintf("#%d", i.Number),
+		Title:      i.Title,
+		Labels:     []Label{},
+	}
+
+	stateName := ""
+	for _, label := range i.Labels {
+		if name, ok := stateNameForLabel(label.Name); ok {
+			if stateName == "" {
+				stateName = name
+			}
+			continue
+		}
+		issue.Labels = append(issue.Labels, convertGitHubLabels([]gitHubLabel{label})...)
+	}
+
+	if i.State == "closed" {
+		stateName = "Done"
+		if i.StateReason == "not_planned" {
+			stateName = "Canceled"
+		}
+		issue.CompletedAt = i.ClosedAt
+	} else if stateName == "" {
+		stateName = "Todo"
+	}
+
+	issue.State = State{Name: stateName}
+
+	return issue
+}
+
+func stateNameForLabel(label string) (string, bool) {
+	for _, state := range StateOrder {
+		if strings.EqualFold(state, label) {
+			return state, true
+		}
+	}
+	return "", false
+}
+
+func convertGitHubLabels(labels []gitHubLabel) []Label {
+	converted := make([]Label, len(labels))
+	for i, label := range labels {
+		converted[i] = Label{
+			Name:  label.Name,
+			Color: "#" + label.Color,
+		}
+	}
+	return converted
+}
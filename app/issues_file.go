@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// FileTracker loads the roadmap from a static YAML or JSON file, which lets the
+// roadmap run locally and in tests without access to a real tracker.
+//
+// The file contains a list of issues, for example:
+//
+//	issues:
+//	  - identifier: BAS-1
+//	    title: Leaderboard
+//	    state: In Progress
+//	    labels:
+//	      - name: Feature
+//	        color: "#bb87fc"
+type FileTracker struct {
+	Path string
+}
+
+type roadmapFile struct {
+	Issues []roadmapFileIssue `json:"issues" yaml:"issues"`
+}
+
+type roadmapFileIssue struct {
+	Identifier  string     `json:"identifier" yaml:"identifier"`
+	Title       string     `json:"title" yaml:"title"`
+	State       string     `json:"state" yaml:"state"`
+	Labels      []Label    `json:"labels" yaml:"labels"`
+	CompletedAt *time.Time `json:"completedAt" yaml:"completedAt"`
+}
+
+func (t *FileTracker) Name() string { return "file" }
+
+func (t *FileTracker) LoadIssues(ctx context.Context) (OrganizedIssues, error) {
+	contents, err := os.ReadFile(t.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file roadmapFile
+	switch filepath.Ext(t.Path) {
+	case ".json":
+		err = json.Unmarshal(contents, &file)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(contents, &file)
+	default:
+		return nil, fmt.Errorf("unsupported roadmap file type %q", filepath.Ext(t.Path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing roadmap file %s: %w", t.Path, err)
+	}
+
+	issues := make([]Issue, len(file.Issues))
+	for i, item := range file.Issues {
+		issues[i] = Issue{
+			Identifier:  item.Identifier,
+			Title:       item.Title,
+			State:       State{Name: item.State},
+			Labels:      item.Labels,
+			CompletedAt: item.CompletedAt,
+		}
+	}
+
+	return organizeIssues(issues), nil
+}
+
+// WriteIssuesFile saves the issues to a roadmap file which FileTracker can load,
+// as YAML or JSON depending on the extension of path.
+func WriteIssuesFile(path string, issues OrganizedIssues) error {
+	var file roadmapFile
+	for _, group := range issues {
+		for _, issue := range group.Items {
+			file.Issues = append(file.Issues, roadmapFileIssue{
+				Identifier:  issue.Identifier,
+				Title:       issue.Title,
+				State:       group.Name,
+				Labels:      issue.Labels,
+				CompletedAt: issue.CompletedAt,
+			})
+		}
+	}
+
+	var contents []byte
+	var err error
+	switch filepath.Ext(path) {
+	case ".json":
+		contents, err = json.MarshalIndent(file, "", "  ")
+	case ".yaml", ".yml":
+		contents, err = yaml.Marshal(file)
+	default:
+		return fmt.Errorf("unsupported roadmap file type %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, contents, 0644)
+}
@@ -2,6 +2,7 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/json"
@@ -9,13 +10,13 @@ import (
 	"html/template"
 	"io"
 	"net/http"
-	"os"
 	"time"
 
 	routing "github.com/go-ozzo/ozzo-routing"
 	"github.com/yuin/goldmark"
 
 	"bob-leaderboard/app/logger"
+	"bob-leaderboard/app/metrics"
 )
 
 type GraphQLRequest struct {
@@ -50,40 +51,19 @@ type LinearIssuesResponse struct {
 	} `json:"data"`
 }
 
-type Issue struct {
-	Identifier  string     `json:"identifier"`
-	Title       string     `json:"title"`
-	State       State      `json:"state"`
-	Labels      []Label    `json:"labels"`
-	CompletedAt *time.Time `json:"completedAt,omitempty"` // Pointer to handle nil (not completed) case
-}
-
-// Label representation for simplified structure.
-type Label struct {
-	Id    string `json:"id,omitempty"`
-	Name  string `json:"name,omitempty"`
-	Color string `json:"color,omitempty"`
-}
-
-type State struct {
-	Id    string `json:"id,omitempty"`
-	Color string `json:"color,omitempty"`
-	Name  string `json:"name,omitempty"`
-	Type  string `json:"type,omitempty"`
-}
+// LinearEndpoint is the GraphQL endpoint of the public Linear API.
+const LinearEndpoint = "https://api.linear.app/graphql"
 
-// StateGroup represents a group of issues by their state.
-type StateGroup struct {
-	Name  string  `json:"name"`
-	Color string  `json:"color"`
-	Items []Issue `json:"items"`
+// LinearTracker loads the roadmap from the issues of a Linear team.
+type LinearTracker struct {
+	Endpoint string
+	ApiKey   string
+	TeamId   string
 }
 
-type OrganizedIssues []StateGroup
-
-var IssuesData *OrganizedIssues = nil
+func (t *LinearTracker) Name() string { return "linear" }
 
-func LoadAllIssues() error {
+func (t *LinearTracker) LoadIssues(ctx context.Context) (OrganizedIssues, error) {
 	// Construct the request payload
 	// https://studio.apollographql.com/public/Linear-API/variant/current/explorer?explorerURLState=N4IgJg9gxgrgtgUwHYBcQC4QEcYIE4CeAFACQoICGcAkmOgAQDKKeAlkgOYCEANPSRDxh8AIQIMAChQ7sKKVhCQB5IaIJ8SAM1YAbcngbUAzkdwAxXfoCU9YAB0k9euSpFWdfi5pgb9x0-pWE1wjIkFhPDEGAVVI9XYoHRhhAEE8KAALVgA3BDpNCh0jBD5tPXxosutbBwCApAhhIxr-Oqd3ZHltfFq2p3kUHRLevqaoNgAHeUURtqMUOQQWvrqkKgRZvqgIHUFNgIBffacdCgAjBCLllacGpuub2-Xjtu3dvBenI9a275vtuATIbkMApFAvCYUPCdB4rDqoVjdD4-Op-X6zNHfb4gA5AA
 	payload := GraphQLRequest{
@@ -113,7 +93,7 @@ func LoadAllIssues() error {
 			}
 		}`,
 		Variables: map[string]interface{}{
-			"teamId":  "BAS",
+			"teamId":  t.TeamId,
 			"orderBy": "updatedAt",
 			/*"filter": map[string]interface{}{
 				"parent": map[string]interface{}{
@@ -127,25 +107,25 @@ func LoadAllIssues() error {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		logger.Error("Error marshalling payload: %v", err)
-		return err
+		return nil, err
 	}
 
 	// Create a new HTTP request
-	req, err := http.NewRequest("POST", "https://api.linear.app/graphql", bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", t.Endpoint, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		logger.Error("Error creating request: %v", err)
-		return err
+		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", os.Getenv("LINEAR_API_KEY"))
+	req.Header.Set("Authorization", t.ApiKey)
 
 	// Make the request using the default client
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
 		logger.Error("Error making request: %v", err)
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
@@ -153,61 +133,35 @@ func LoadAllIssues() error {
 		body, err := io.ReadAll(resp.Body)
 		if err != nil {
 			logger.Error("Error reading response body: %v", err)
-			return err
+			return nil, err
 		}
 
 		logger.Error("Error response: %v", string(body))
 
-		return err
+		return nil, fmt.Errorf("linear responded with status %d", resp.StatusCode)
 	}
 
 	// Read the response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		logger.Error("Error reading response body: %v", err)
-		return err
+		return nil, err
 	}
 
 	var response LinearIssuesResponse
 	if err := json.Unmarshal(body, &response); err != nil {
 		logger.Error("Error unmarshalling response: %v", err)
-		return err
+		return nil, err
 	}
 
-	OrganizeIssues(response)
-
-	return nil
+	return OrganizeIssues(response), nil
 }
 
-var StateOrder = []string{"Backlog", "Todo", "In Progress", "Done", "Canceled", "Duplicate"}
-var StateColors = []string{"#95a2b3", "#e2e2e2", "#f2c94c", "#5e6ad2", "#95a2b3", "#95a2b3"}
-
-func OrganizeIssues(response LinearIssuesResponse) {
-
-	organized := make(OrganizedIssues, len(StateOrder))
-	stateIndexMap := make(map[string]int)
-	for i, stateName := range StateOrder {
-		organized[i] = StateGroup{
-			Name:  stateName,
-			Color: StateColors[i],
-			Items: []Issue{},
-		}
-		stateIndexMap[stateName] = i
-	}
+// OrganizeIssues converts a Linear issues response into OrganizedIssues.
+func OrganizeIssues(response LinearIssuesResponse) OrganizedIssues {
+	issues := make([]Issue, 0, len(response.Data.Team.Issues.Nodes))
 
 	for _, node := range response.Data.Team.Issues.Nodes {
-		// Get the index of the current state from the map
-		index, exists := stateIndexMap[node.State.Name]
-		if !exists {
-			// Handle unknown state; could log a warning or dynamically add new states if needed
-			continue
-		}
-
-		// Update state color if necessary
-		if organized[index].Color == "" {
-			organized[index].Color = node.State.Color
-		}
-
 		// Prepare labels for the simplified issue
 		labels := make([]Label, len(node.Labels.Nodes))
 		for j, label := range node.Labels.Nodes {
@@ -221,7 +175,11 @@ func OrganizeIssues(response LinearIssuesResponse) {
 		issue := Issue{
 			Identifier: node.Identifier,
 			Title:      node.Title,
-			Labels:     labels,
+			State: State{
+				Name:  node.State.Name,
+				Color: node.State.Color,
+			},
+			Labels: labels,
 		}
 
 		/*descriptionHTML, err := ConvertMarkdownToHTML([]byte(node.Description))
@@ -232,14 +190,14 @@ func OrganizeIssues(response LinearIssuesResponse) {
 		}*/
 
 		if !node.CompletedAt.IsZero() {
-			issue.CompletedAt = &node.CompletedAt
+			completedAt := node.CompletedAt
+			issue.CompletedAt = &completedAt
 		}
 
-		// Append the issue to the appropriate state group
-		organized[index].Items = append(organized[index].Items, issue)
+		issues = append(issues, issue)
 	}
 
-	IssuesData = &organized
+	return organizeIssues(issues)
 }
 
 func ConvertMarkdownToHTML(markdown []byte) (string, error) {
@@ -311,146 +269,156 @@ func HandleLinearWebhooks(c *routing.Context) error {
 	}
 	defer c.Request.Body.Close()
 
-	signature := hmac.New(sha256.New, []byte(os.Getenv("LINEAR_WEBHOOK_SECRET")))
+	signature := hmac.New(sha256.New, []byte(Settings.Roadmap.Linear.WebhookSecret))
 	signature.Write(body)
 	expectedSig := fmt.Sprintf("%x", signature.Sum(nil))
 	if expectedSig != c.Request.Header.Get("linear-signature") {
+		metrics.LinearWebhooks.WithLabelValues("", "invalid_signature").Inc()
 		return routing.NewHTTPError(http.StatusBadRequest, "Invalid signature")
 	}
 
 	var webhooks LinearWebhookBody
 	if err := json.Unmarshal(body, &webhooks); err != nil {
+		metrics.LinearWebhooks.WithLabelValues("", "invalid_body").Inc()
 		logger.Error("Error unmarshalling webhook body: %v", err)
 		return err
 	}
 
-	UpdateIssuesFromWebhook(webhooks)
+	UpdateIssuesFromWebhook(c.Request.Context(), webhooks)
+	metrics.LinearWebhooks.WithLabelValues(webhooks.Action, "ok").Inc()
 
 	return c.Write(map[string]interface{}{"status": "ok"})
 }
 
-func UpdateIssuesFromWebhook(data LinearWebhookBody) {
-	if IssuesData == nil {
-		if err := LoadAllIssues(); err != nil {
-			logger.Error("Error loading issues: %v", err)
-			return
-		}
-		return
-	}
-	switch data.Action {
-	case "create":
-		{
-			for i, group := range *IssuesData {
-				if group.Name != data.Data.State.Name {
-					continue
-				}
+// UpdateIssuesFromWebhook applies an Issue webhook to the cached roadmap, or loads the
+// roadmap when it is not cached yet.
+func UpdateIssuesFromWebhook(ctx context.Context, data LinearWebhookBody) {
+	cached := updateIssues(func(issues OrganizedIssues) bool {
+		changed := false
+		switch data.Action {
+		case "create":
+			{
+				for i, group := range issues {
+					if group.Name != data.Data.State.Name {
+						continue
+					}
 
-				issue := Issue{
-					Identifier:  data.Data.Identifier,
-					Title:       data.Data.Title,
-					State:       data.Data.State,
-					Labels:      data.Data.Labels,
-					CompletedAt: nil,
-				}
+					issue := Issue{
+						Identifier:  data.Data.Identifier,
+						Title:       data.Data.Title,
+						State:       data.Data.State,
+						Labels:      data.Data.Labels,
+						CompletedAt: nil,
+					}
 
-				if !data.Data.CompletedAt.IsZero() {
-					issue.CompletedAt = &data.Data.CompletedAt
-				}
+					if !data.Data.CompletedAt.IsZero() {
+						issue.CompletedAt = &data.Data.CompletedAt
+					}
+
+					issues[i].Items = append(issues[i].Items, issue)
+					changed = true
 
-				(*IssuesData)[i].Items = append((*IssuesData)[i].Items, issue)
+					break
+				}
 
 				break
 			}
+		case "update":
+			{
+				groupIdx := -1
+				newGroupIdx := -1
+				itemIdx := -1
+				for gIdx, group := range issues {
+					// if group.Name != data.Data.State.Name {
+					// 	continue
+					// }
+
+					for iIdx, item := range group.Items {
+						if item.Identifier != data.Data.Identifier {
+							continue
+						}
 
-			break
-		}
-	case "update":
-		{
-			groupIdx := -1
-			newGroupIdx := -1
-			itemIdx := -1
-			for gIdx, group := range *IssuesData {
-				// if group.Name != data.Data.State.Name {
-				// 	continue
-				// }
-
-				for iIdx, item := range group.Items {
-					if item.Identifier != data.Data.Identifier {
-						continue
-					}
+						groupIdx = gIdx
+						itemIdx = iIdx
+						/*	item.Title = data.Data.Title
+							item.Labels = data.Data.Labels
+							item.State = data.Data.State
+							item.CompletedAt = nil
+							if !data.Data.CompletedAt.IsZero() {
+								item.CompletedAt = &data.Data.CompletedAt
+							}
 
-					groupIdx = gIdx
-					itemIdx = iIdx
-					/*	item.Title = data.Data.Title
-						item.Labels = data.Data.Labels
-						item.State = data.Data.State
-						item.CompletedAt = nil
-						if !data.Data.CompletedAt.IsZero() {
-							item.CompletedAt = &data.Data.CompletedAt
-						}
+							issues[gIdx].Items[iIdx] = item
+						*/
 
-						(*IssuesData)[gIdx].Items[iIdx] = item
-					*/
+						break
+					}
 
-					break
+					if groupIdx != -1 && itemIdx != -1 {
+						break
+					}
 				}
 
-				if groupIdx != -1 && itemIdx != -1 {
-					break
+				for gIdx, group := range issues {
+					if group.Name == data.Data.State.Name {
+						newGroupIdx = gIdx
+						break
+					}
 				}
-			}
 
-			for gIdx, group := range *IssuesData {
-				if group.Name == data.Data.State.Name {
-					newGroupIdx = gIdx
-					break
-				}
-			}
+				if groupIdx != -1 && itemIdx != -1 && newGroupIdx != -1 {
+					item := issues[groupIdx].Items[itemIdx]
+					item.Title = data.Data.Title
+					item.Labels = data.Data.Labels
+					item.State = data.Data.State
+					item.CompletedAt = nil
+					if !data.Data.CompletedAt.IsZero() {
+						item.CompletedAt = &data.Data.CompletedAt
+					}
 
-			if groupIdx != -1 && itemIdx != -1 && newGroupIdx != -1 {
-				item := (*IssuesData)[groupIdx].Items[itemIdx]
-				item.Title = data.Data.Title
-				item.Labels = data.Data.Labels
-				item.State = data.Data.State
-				item.CompletedAt = nil
-				if !data.Data.CompletedAt.IsZero() {
-					item.CompletedAt = &data.Data.CompletedAt
+					// Remove from the old group
+					issues[groupIdx].Items = append(issues[groupIdx].Items[:itemIdx], issues[groupIdx].Items[itemIdx+1:]...)
+					// Add to the new group
+					issues[newGroupIdx].Items = append(issues[newGroupIdx].Items, item)
+					changed = true
 				}
 
-				// Remove from the old group
-				(*IssuesData)[groupIdx].Items = append((*IssuesData)[groupIdx].Items[:itemIdx], (*IssuesData)[groupIdx].Items[itemIdx+1:]...)
-				// Add to the new group
-				(*IssuesData)[newGroupIdx].Items = append((*IssuesData)[newGroupIdx].Items, item)
+				break
 			}
+		case "remove":
+			{
+				for i, group := range issues {
+					if group.Name != data.Data.State.Name {
+						continue
+					}
 
-			break
-		}
-	case "remove":
-		{
-			for i, group := range *IssuesData {
-				if group.Name != data.Data.State.Name {
-					continue
-				}
+					locatedItemIdx := -1
 
-				locatedItemIdx := -1
+					for itemIdx, item := range group.Items {
+						if item.Identifier != data.Data.Identifier {
+							continue
+						}
+						locatedItemIdx = itemIdx
+						break
+					}
 
-				for itemIdx, item := range group.Items {
-					if item.Identifier != data.Data.Identifier {
-						continue
+					if locatedItemIdx != -1 {
+						issues[i].Items = append(issues[i].Items[:locatedItemIdx], issues[i].Items[locatedItemIdx+1:]...)
+						changed = true
 					}
-					locatedItemIdx = itemIdx
-					break
-				}
 
-				if locatedItemIdx != -1 {
-					(*IssuesData)[i].Items = append((*IssuesData)[i].Items[:locatedItemIdx], (*IssuesData)[i].Items[locatedItemIdx+1:]...)
+					break
 				}
 
 				break
 			}
 
-			break
 		}
-
+		return changed
+	})
+	if !cached {
+		if err := LoadAllIssues(ctx); err != nil {
+			logger.Error("Error loading issues: %v", err)
+		}
 	}
 }
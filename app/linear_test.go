@@ -0,0 +1,246 @@
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	routing "github.com/go-ozzo/ozzo-routing"
+
+	"bob-leaderboard/app"
+	"bob-leaderboard/app/lineartest"
+)
+
+// useLinear points the roadmap at a fake Linear server serving the fixtures, restoring
+// the tracker and the cached roadmap when the test ends.
+func useLinear(t *testing.T) *lineartest.Server {
+	t.Helper()
+	server := lineartest.NewServer(lineartest.Fixtures()...)
+	tracker, settings := app.Tracker, app.Settings
+	t.Cleanup(func() {
+		server.Close()
+		app.Tracker, app.Settings = tracker, settings
+		app.ClearCachedIssues()
+	})
+
+	app.Tracker = server.Tracker()
+	app.ClearCachedIssues()
+	app.Settings.Roadmap.Linear.WebhookSecret = server.WebhookSecret
+	return server
+}
+
+// issueState returns the name of the roadmap group holding the issue.
+func issueState(t *testing.T, identifier string) (string, bool) {
+	t.Helper()
+	issues, ok := app.CachedIssues()
+	if !ok {
+		t.Fatal("the roadmap is not loaded")
+	}
+	for _, group := range issues {
+		for _, issue := range group.Items {
+			if issue.Identifier == identifier {
+				return group.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+func TestLoadAllIssues(t *testing.T) {
+	server := useLinear(t)
+	version := app.RoadmapVersion()
+
+	if err := app.LoadAllIssues(context.Background()); err != nil {
+		t.Fatalf("LoadAllIssues: %v", err)
+	}
+	if app.RoadmapVersion() == version {
+		t.Error("the roadmap version did not change")
+	}
+	if requests := server.Requests(); len(requests) != 1 || requests[0].Variables["teamId"] != "BAS" {
+		t.Errorf("requests = %+v, want a single query for team BAS", requests)
+	}
+
+	groups, _ := app.CachedIssues()
+	if len(groups) != len(app.StateOrder) {
+		t.Fatalf("got %d groups, want %d", len(groups), len(app.StateOrder))
+	}
+	for i, group := range groups {
+		if group.Name != app.StateOrder[i] {
+			t.Errorf("group %d is %q, want %q", i, group.Name, app.StateOrder[i])
+		}
+		if len(group.Items) != 1 {
+			t.Errorf("group %q holds %d issues, want 1", group.Name, len(group.Items))
+		}
+	}
+	if _, ok := issueState(t, "BAS-7"); ok {
+		t.Error("the Triage issue BAS-7 is on the roadmap")
+	}
+	if done := groups[3].Items[0]; done.CompletedAt == nil || done.CompletedAt.Year() != 2024 {
+		t.Errorf("BAS-4 completedAt = %v, want the fixture time", done.CompletedAt)
+	}
+
+	server.FailWith(http.StatusInternalServerError)
+	if err := app.LoadAllIssues(context.Background()); err == nil {
+		t.Error("LoadAllIssues succeeded against a failing tracker")
+	}
+	if cached, _ := app.CachedIssues(); len(cached) != len(groups) {
+		t.Error("a failed load replaced the cached roadmap")
+	}
+}
+
+func TestOrganizeIssues(t *testing.T) {
+	var response app.LinearIssuesResponse
+	err := json.Unmarshal([]byte(`{"data": {"team": {"issues": {"nodes": [
+		{"identifier": "BAS-1", "title": "Controller support", "state": {"name": "Todo", "color": "#e2e2e2"},
+			"labels": {"nodes": [{"name": "Feature", "color": "#bb87fc"}]}},
+		{"identifier": "BAS-2", "title": "Global leaderboard", "state": {"name": "Done", "color": "#5e6ad2"},
+			"labels": {"nodes": []}, "completedAt": "2024-03-01T12:00:00Z"},
+		{"identifier": "BAS-3", "title": "Internal triage", "state": {"name": "Triage"}, "labels": {"nodes": []}}
+	]}}}}`), &response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	organized := app.OrganizeIssues(response)
+	if len(organized) != len(app.StateOrder) {
+		t.Fatalf("got %d groups, want %d", len(organized), len(app.StateOrder))
+	}
+
+	todo := organized[1].Items
+	if len(todo) != 1 || todo[0].Identifier != "BAS-1" || todo[0].CompletedAt != nil {
+		t.Fatalf("Todo = %+v, want BAS-1 without completedAt", todo)
+	}
+	if labels := todo[0].Labels; len(labels) != 1 || labels[0] != (app.Label{Name: "Feature", Color: "#bb87fc"}) {
+		t.Errorf("BAS-1 labels = %+v", labels)
+	}
+
+	done := organized[3].Items
+	if len(done) != 1 || done[0].CompletedAt == nil || done[0].Labels == nil {
+		t.Fatalf("Done = %+v, want BAS-2 with completedAt and empty labels", done)
+	}
+
+	count := 0
+	for _, group := range organized {
+		count += len(group.Items)
+	}
+	if count != 2 {
+		t.Errorf("organized %d issues, want the Triage issue dropped", count)
+	}
+}
+
+func TestLinearWebhooks(t *testing.T) {
+	server := useLinear(t)
+	if err := app.LoadAllIssues(context.Background()); err != nil {
+		t.Fatalf("LoadAllIssues: %v", err)
+	}
+
+	router := routing.New()
+	router.Post("/webhooks/linear", app.HandleLinearWebhooks)
+	api := httptest.NewServer(router)
+	defer api.Close()
+	url := api.URL + "/webhooks/linear"
+
+	send := func(action string, issue app.Issue) {
+		t.Helper()
+		version := app.RoadmapVersion()
+		response, err := server.SendWebhook(url, lineartest.WebhookBody(action, issue))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("%s webhook: status %d", action, response.StatusCode)
+		}
+		if app.RoadmapVersion() == version {
+			t.Errorf("%s webhook: the roadmap version did not change", action)
+		}
+	}
+
+	issue := lineartest.Issue("BAS-8", "Endless mode", "Todo")
+	send("create", issue)
+	if state, ok := issueState(t, "BAS-8"); !ok || state != "Todo" {
+		t.Fatalf("after create BAS-8 is in %q, want Todo", state)
+	}
+
+	issue = lineartest.Issue("BAS-8", "Endless mode", "In Progress")
+	send("update", issue)
+	if state, ok := issueState(t, "BAS-8"); !ok || state != "In Progress" {
+		t.Fatalf("after update BAS-8 is in %q, want In Progress", state)
+	}
+
+	send("remove", issue)
+	if state, ok := issueState(t, "BAS-8"); ok {
+		t.Fatalf("after remove BAS-8 is still in %q", state)
+	}
+
+	// A webhook which changes nothing keeps the version, so the cached pages stay valid
+	version := app.RoadmapVersion()
+	app.UpdateIssuesFromWebhook(context.Background(), lineartest.WebhookBody("remove", issue))
+	app.UpdateIssuesFromWebhook(context.Background(), lineartest.WebhookBody("update", issue))
+	if app.RoadmapVersion() != version {
+		t.Error("webhooks of an issue which is not on the roadmap changed the version")
+	}
+
+	t.Run("BadSignature", func(t *testing.T) {
+		version := app.RoadmapVersion()
+		request, err := server.NewWebhookRequest(url, lineartest.WebhookBody("create", issue))
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Header.Set("linear-signature", server.Sign([]byte("another body")))
+
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusBadRequest {
+			t.Errorf("status %d, want %d", response.StatusCode, http.StatusBadRequest)
+		}
+		if _, ok := issueState(t, "BAS-8"); ok || app.RoadmapVersion() != version {
+			t.Error("a badly signed webhook changed the roadmap")
+		}
+	})
+}
+
+func TestRoadmapReadsDuringWebhooks(t *testing.T) {
+	useLinear(t)
+	ctx := context.Background()
+	if err := app.LoadAllIssues(ctx); err != nil {
+		t.Fatalf("LoadAllIssues: %v", err)
+	}
+
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				issues, _ := app.CachedIssues()
+				for _, group := range issues {
+					for _, issue := range group.Items {
+						_ = issue.Identifier
+					}
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 100; i++ {
+		issue := lineartest.Issue(fmt.Sprintf("BAS-%d", 100+i), "Endless mode", "Todo")
+		app.UpdateIssuesFromWebhook(ctx, lineartest.WebhookBody("create", issue))
+		app.UpdateIssuesFromWebhook(ctx, lineartest.WebhookBody("remove", issue))
+	}
+	close(done)
+	readers.Wait()
+}
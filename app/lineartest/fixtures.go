@@ -0,0 +1,66 @@
+package lineartest
+
+import (
+	"time"
+
+	"bob-leaderboard/app"
+)
+
+// Issue builds an issue fixture in the given state.
+func Issue(identifier, title, state string, labels ...app.Label) app.Issue {
+	if labels == nil {
+		labels = []app.Label{}
+	}
+	return app.Issue{
+		Identifier: identifier,
+		Title:      title,
+		State:      app.State{Name: state},
+		Labels:     labels,
+	}
+}
+
+// CompletedIssue builds a Done issue fixture completed at the given time.
+func CompletedIssue(identifier, title string, completedAt time.Time, labels ...app.Label) app.Issue {
+	issue := Issue(identifier, title, "Done", labels...)
+	issue.CompletedAt = &completedAt
+	return issue
+}
+
+// Fixtures is a small roadmap covering every state in app.StateOrder plus one
+// issue in a state the roadmap doesn't know about.
+func Fixtures() []app.Issue {
+	feature := app.Label{Name: "Feature", Color: "#bb87fc"}
+	bug := app.Label{Name: "Bug", Color: "#eb5757"}
+
+	return []app.Issue{
+		Issue("BAS-1", "Controller support", "Backlog"),
+		Issue("BAS-2", "Steam achievements", "Todo", feature),
+		Issue("BAS-3", "Seasonal leaderboards", "In Progress", feature),
+		CompletedIssue("BAS-4", "Global leaderboard", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), feature),
+		Issue("BAS-5", "Tower placement desync", "Canceled", bug),
+		Issue("BAS-6", "Tower placement desync (again)", "Duplicate", bug),
+		Issue("BAS-7", "Internal triage", "Triage"),
+	}
+}
+
+// WebhookBody builds an Issue webhook delivery for the given action ("create", "update" or "remove").
+func WebhookBody(action string, issue app.Issue) app.LinearWebhookBody {
+	body := app.LinearWebhookBody{
+		Action:           action,
+		CreatedAt:        time.Now().UTC(),
+		Type:             "Issue",
+		OrganizationId:   "lineartest-org",
+		WebhookTimestamp: time.Now().UnixMilli(),
+		WebhookId:        "lineartest-webhook",
+	}
+	body.Data.Identifier = issue.Identifier
+	body.Data.Title = issue.Title
+	body.Data.State = issue.State
+	body.Data.Labels = issue.Labels
+	if issue.CompletedAt != nil {
+		body.Data.CompletedAt = *issue.CompletedAt
+	}
+	body.Data.Team.Key = "BAS"
+
+	return body
+}
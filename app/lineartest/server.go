@@ -0,0 +1,182 @@
+// Package lineartest provides an in-process fake of the Linear GraphQL API and its
+// webhooks, so the roadmap can be exercised without a real LINEAR_API_KEY.
+package lineartest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"bob-leaderboard/app"
+)
+
+// Server is a fake Linear API serving the team issues query from fixtures.
+type Server struct {
+	*httptest.Server
+
+	// ApiKey is the expected Authorization header, requests with a different one get a 401.
+	// Leave empty to accept any key.
+	ApiKey string
+	// WebhookSecret is used to sign webhook deliveries, it must match Roadmap.Linear.WebhookSecret
+	// for HandleLinearWebhooks to accept them.
+	WebhookSecret string
+
+	mu       sync.Mutex
+	issues   []app.Issue
+	status   int
+	requests []app.GraphQLRequest
+}
+
+// NewServer starts a fake Linear server serving the given issues.
+func NewServer(issues ...app.Issue) *Server {
+	s := &Server{
+		WebhookSecret: "lineartest-secret",
+		issues:        issues,
+		status:        http.StatusOK,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handleGraphQL))
+	return s
+}
+
+// Tracker returns a LinearTracker pointed at the fake server.
+func (s *Server) Tracker() *app.LinearTracker {
+	return &app.LinearTracker{
+		Endpoint: s.URL + "/graphql",
+		ApiKey:   s.ApiKey,
+		TeamId:   "BAS",
+	}
+}
+
+// SetIssues replaces the issues served by the fake.
+func (s *Server) SetIssues(issues ...app.Issue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.issues = issues
+}
+
+// FailWith makes every following query respond with the given status code,
+// pass http.StatusOK to restore normal responses.
+func (s *Server) FailWith(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+// Requests returns the GraphQL requests received so far.
+func (s *Server) Requests() []app.GraphQLRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]app.GraphQLRequest{}, s.requests...)
+}
+
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/graphql" {
+		http.NotFound(w, r)
+		return
+	}
+	if s.ApiKey != "" && r.Header.Get("Authorization") != s.ApiKey {
+		http.Error(w, `{"errors":[{"message":"Authentication required"}]}`, http.StatusUnauthorized)
+		return
+	}
+
+	var request app.GraphQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, request)
+	status := s.status
+	issues := s.issues
+	s.mu.Unlock()
+
+	if status != http.StatusOK {
+		http.Error(w, `{"errors":[{"message":"lineartest failure"}]}`, status)
+		return
+	}
+	if !strings.Contains(request.Query, "team(id: $teamId)") {
+		http.Error(w, `{"errors":[{"message":"unsupported query"}]}`, http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(issuesResponse(issues))
+}
+
+// issuesResponse renders issues in the shape returned by the Linear team issues query.
+func issuesResponse(issues []app.Issue) map[string]any {
+	nodes := make([]map[string]any, len(issues))
+	for i, issue := range issues {
+		labels := make([]map[string]any, len(issue.Labels))
+		for j, label := range issue.Labels {
+			labels[j] = map[string]any{"name": label.Name, "color": label.Color}
+		}
+
+		var completedAt any = nil
+		if issue.CompletedAt != nil {
+			completedAt = issue.CompletedAt.Format(time.RFC3339)
+		}
+
+		nodes[i] = map[string]any{
+			"identifier":  issue.Identifier,
+			"title":       issue.Title,
+			"description": "",
+			"state":       map[string]any{"name": issue.State.Name, "color": issue.State.Color},
+			"labels":      map[string]any{"nodes": labels},
+			"completedAt": completedAt,
+			"parent":      nil,
+		}
+	}
+
+	return map[string]any{
+		"data": map[string]any{
+			"team": map[string]any{
+				"issues": map[string]any{"nodes": nodes},
+			},
+		},
+	}
+}
+
+// Sign returns the linear-signature header value for a webhook body.
+func (s *Server) Sign(body []byte) string {
+	signature := hmac.New(sha256.New, []byte(s.WebhookSecret))
+	signature.Write(body)
+	return fmt.Sprintf("%x", signature.Sum(nil))
+}
+
+// NewWebhookRequest builds a signed webhook delivery for the given body, ready to be
+// served by a handler or sent with an http.Client.
+func (s *Server) NewWebhookRequest(url string, body app.LinearWebhookBody) (*http.Request, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("linear-signature", s.Sign(payload))
+	req.Header.Set("linear-delivery", fmt.Sprintf("lineartest-%d", time.Now().UnixNano()))
+	req.Header.Set("linear-event", body.Type)
+
+	return req, nil
+}
+
+// SendWebhook delivers a signed webhook to url.
+func (s *Server) SendWebhook(url string, body app.LinearWebhookBody) (*http.Response, error) {
+	req, err := s.NewWebhookRequest(url, body)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
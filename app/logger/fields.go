@@ -0,0 +1,137 @@
+package logger
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/go-ozzo/ozzo-log"
+)
+
+// Field is a key/value pair attached to a structured log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// FieldLogger logs messages with a fixed set of fields. Its messages are logged as is,
+// they are not printf formats.
+type FieldLogger struct {
+	fields []Field
+}
+
+// With returns a logger adding the given alternating keys and values to every entry.
+func With(keysAndValues ...interface{}) *FieldLogger {
+	return &FieldLogger{fields: toFields(nil, keysAndValues)}
+}
+
+// With returns a copy of the logger with additional fields.
+func (l *FieldLogger) With(keysAndValues ...interface{}) *FieldLogger {
+	return &FieldLogger{fields: toFields(l.fields, keysAndValues)}
+}
+
+// Fields returns the fields of the logger.
+func (l *FieldLogger) Fields() []Field {
+	return l.fields
+}
+
+func (l *FieldLogger) Critical(message string, keysAndValues ...interface{}) {
+	l.log(log.LevelCritical, message, keysAndValues)
+}
+func (l *FieldLogger) Error(message string, keysAndValues ...interface{}) {
+	l.log(log.LevelError, message, keysAndValues)
+}
+func (l *FieldLogger) Warning(message string, keysAndValues ...interface{}) {
+	l.log(log.LevelWarning, message, keysAndValues)
+}
+func (l *FieldLogger) Notice(message string, keysAndValues ...interface{}) {
+	l.log(log.LevelNotice, message, keysAndValues)
+}
+func (l *FieldLogger) Info(message string, keysAndValues ...interface{}) {
+	l.log(log.LevelInfo, message, keysAndValues)
+}
+func (l *FieldLogger) Debug(message string, keysAndValues ...interface{}) {
+	l.log(log.LevelDebug, message, keysAndValues)
+}
+
+func (l *FieldLogger) log(level log.Level, message string, keysAndValues []interface{}) {
+	fields := toFields(l.fields, keysAndValues)
+
+	// A logger per entry is cheap, it only carries the formatter holding the fields
+	Logger.GetLogger(Logger.Category, fieldsFormatter(fields)).Log(level, message)
+}
+
+// toFields appends the alternating keys and values to a copy of fields. A value
+// without a key is kept under "extra".
+func toFields(fields []Field, keysAndValues []interface{}) []Field {
+	result := make([]Field, len(fields), len(fields)+len(keysAndValues)/2+1)
+	copy(result, fields)
+
+	for i := 0; i < len(keysAndValues); i += 2 {
+		if i+1 == len(keysAndValues) {
+			result = append(result, Field{"extra", keysAndValues[i]})
+			break
+		}
+		result = append(result, Field{fmt.Sprint(keysAndValues[i]), keysAndValues[i+1]})
+	}
+	return result
+}
+
+// entryFields holds the fields of the entries waiting to be written by the JSON targets,
+// as ozzo-log entries only carry a message.
+var entryFields sync.Map
+
+// jsonTargets is the number of open JSON targets, each of them reads the fields of every entry.
+var jsonTargets atomic.Int32
+
+type pendingFields struct {
+	fields  []Field
+	readers atomic.Int32
+}
+
+// fieldsFormatter formats the entry like the default formatter, with the fields appended
+// as key=value pairs, and keeps the fields for the JSON targets.
+func fieldsFormatter(fields []Field) log.Formatter {
+	return func(l *log.Logger, e *log.Entry) string {
+		if readers := jsonTargets.Load(); readers > 0 && len(fields) > 0 {
+			pending := &pendingFields{fields: fields}
+			pending.readers.Store(readers)
+			entryFields.Store(e, pending)
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "%v [%v][%v] %v", e.Time.Format(time.RFC3339), e.Level, e.Category, e.Message)
+		for _, field := range fields {
+			b.WriteString(" ")
+			b.WriteString(field.Key)
+			b.WriteString("=")
+			b.WriteString(formatValue(field.Value))
+		}
+		b.WriteString(e.CallStack)
+		return b.String()
+	}
+}
+
+// takeFields returns the fields of an entry, forgetting them once every JSON target has read them.
+func takeFields(e *log.Entry) []Field {
+	value, ok := entryFields.Load(e)
+	if !ok {
+		return nil
+	}
+	pending := value.(*pendingFields)
+	if pending.readers.Add(-1) <= 0 {
+		entryFields.Delete(e)
+	}
+	return pending.fields
+}
+
+func formatValue(value interface{}) string {
+	s := fmt.Sprint(value)
+	if s == "" || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
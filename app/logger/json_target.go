@@ -0,0 +1,127 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/go-ozzo/ozzo-log"
+)
+
+// JSONTarget writes every entry as a single JSON object per line, with the structured
+// fields of the entry as top level keys, to Writer or, when set, to FileName.
+type JSONTarget struct {
+	*log.Filter
+	Writer   io.Writer // the writer used when FileName is empty, defaults to stdout
+	FileName string    // the file to append the entries to
+
+	errWriter io.Writer
+	file      *os.File
+	close     chan bool
+}
+
+// NewJSONTarget creates a JSONTarget writing to stdout.
+func NewJSONTarget() *JSONTarget {
+	return &JSONTarget{
+		Filter: &log.Filter{MaxLevel: log.LevelDebug},
+		Writer: os.Stdout,
+		close:  make(chan bool),
+	}
+}
+
+// Open prepares the target for processing log messages.
+func (t *JSONTarget) Open(errWriter io.Writer) error {
+	t.Filter.Init()
+	t.errWriter = errWriter
+
+	if t.FileName != "" {
+		file, err := os.OpenFile(t.FileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0660)
+		if err != nil {
+			return fmt.Errorf("JSONTarget was unable to open the log file: %w", err)
+		}
+		t.file = file
+		t.Writer = file
+	}
+	if t.Writer == nil {
+		return errors.New("JSONTarget.Writer cannot be nil")
+	}
+
+	jsonTargets.Add(1)
+	return nil
+}
+
+// Process writes a log entry.
+func (t *JSONTarget) Process(e *log.Entry) {
+	if e == nil {
+		if t.file != nil {
+			t.file.Close()
+		}
+		jsonTargets.Add(-1)
+		t.close <- true
+		return
+	}
+
+	// The fields are taken before filtering so they are released for entries this target skips
+	fields := takeFields(e)
+	if !t.Allow(e) {
+		return
+	}
+
+	if _, err := t.Writer.Write(marshalEntry(e, fields)); err != nil {
+		fmt.Fprintf(t.errWriter, "JSONTarget was unable to write an entry: %v\n", err)
+	}
+}
+
+// Close closes the target.
+func (t *JSONTarget) Close() {
+	<-t.close
+}
+
+// marshalEntry encodes the entry with its keys in a stable order. Values which cannot
+// be encoded are written as strings.
+func marshalEntry(e *log.Entry, fields []Field) []byte {
+	var b bytes.Buffer
+	b.WriteString("{")
+
+	write := func(key string, value interface{}) {
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			encoded, _ = json.Marshal(fmt.Sprint(value))
+		}
+		encodedKey, _ := json.Marshal(key)
+
+		if b.Len() > 1 {
+			b.WriteString(",")
+		}
+		b.Write(encodedKey)
+		b.WriteString(":")
+		b.Write(encoded)
+	}
+
+	write("time", e.Time.Format(time.RFC3339Nano))
+	write("level", strings.ToLower(e.Level.String()))
+	write("category", e.Category)
+	write("message", e.Message)
+	for _, field := range fields {
+		switch field.Key {
+		case "time", "level", "category", "message":
+			write("field."+field.Key, field.Value)
+		default:
+			write(field.Key, field.Value)
+		}
+	}
+	if e.CallStack != "" {
+		write("callStack", strings.TrimPrefix(e.CallStack, "\n"))
+	}
+
+	b.WriteString("}\n")
+	return b.Bytes()
+}
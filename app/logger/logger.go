@@ -19,6 +19,7 @@ func Debug(format string, a ...interface{})     { Logger.Debug(format, a...) }
 func Init(c *config.Config) {
 	c.Register("ConsoleTarget", log.NewConsoleTarget)
 	c.Register("FileTarget", log.NewFileTarget)
+	c.Register("JSONTarget", NewJSONTarget)
 
 	if err := c.Configure(Logger, "Logger"); err != nil {
 		panic(err)
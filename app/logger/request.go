@@ -0,0 +1,165 @@
+package logger
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	routing "github.com/go-ozzo/ozzo-routing"
+	"github.com/go-ozzo/ozzo-routing/access"
+)
+
+// RequestIDHeader is the header carrying the id of a request, read from the client
+// when it sets one and always written to the response.
+const RequestIDHeader = "X-Request-Id"
+
+const maxRequestIDLength = 128
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the logger.
+func NewContext(ctx context.Context, l *FieldLogger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, which logs the request id of the
+// request being handled, or a logger without fields.
+func FromContext(ctx context.Context) *FieldLogger {
+	if l, ok := ctx.Value(contextKey{}).(*FieldLogger); ok {
+		return l
+	}
+	return With()
+}
+
+// RequestID returns the id of the request handled with ctx, or an empty string.
+func RequestID(ctx context.Context) string {
+	for _, field := range FromContext(ctx).fields {
+		if field.Key == "requestId" {
+			return field.Value.(string)
+		}
+	}
+	return ""
+}
+
+// RequestIDHandler assigns an id to every request, reusing a valid X-Request-Id sent by
+// the client, writes it to the response and stamps it on the logger of the request context.
+func RequestIDHandler(c *routing.Context) error {
+	id := c.Request.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+
+	c.Response.Header().Set(RequestIDHeader, id)
+	c.Request = c.Request.WithContext(NewContext(c.Request.Context(), With("requestId", id)))
+
+	return c.Next()
+}
+
+// AccessLogger logs every request once it has been handled.
+func AccessLogger(c *routing.Context) error {
+	start := time.Now()
+	req := c.Request
+	response := &responseRecorder{ResponseWriter: c.Response, status: http.StatusOK}
+	c.Response = response
+
+	err := c.Next()
+
+	FromContext(req.Context()).Debug("Request handled",
+		"method", req.Method,
+		"path", req.URL.String(),
+		"status", response.status,
+		"bytes", response.written,
+		"durationMs", float64(time.Since(start).Microseconds())/1000,
+		"clientIp", access.GetClientIP(req),
+	)
+	return err
+}
+
+// ErrorLogger logs the errors returned by the handlers with the fields of the request,
+// it has to be used after fault.ErrorHandler which writes them.
+func ErrorLogger(c *routing.Context) error {
+	err := c.Next()
+	if err == nil {
+		return nil
+	}
+
+	status := http.StatusInternalServerError
+	if httpError, ok := err.(routing.HTTPError); ok {
+		status = httpError.StatusCode()
+	}
+	if status >= 500 {
+		FromContext(c.Request.Context()).Error("Request failed", "status", status, "error", err)
+	} else {
+		FromContext(c.Request.Context()).Debug("Request rejected", "status", status, "error", err)
+	}
+	return err
+}
+
+// responseRecorder keeps the status and size of a response. Unlike access.LogResponseWriter
+// it can be flushed and hijacked.
+type responseRecorder struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	written, err := r.ResponseWriter.Write(p)
+	r.written += int64(written)
+	return written, err
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hands the connection over for a WebSocket, recording the switch of protocols.
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("logger: the response can not be hijacked")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the response of the server.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// validRequestID accepts short ids made of letters, digits and -_.:
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-' || r == '_' || r == '.' || r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
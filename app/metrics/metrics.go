@@ -0,0 +1,225 @@
+// Package metrics holds the Prometheus collectors of the application. They are always
+// recorded, but only exposed when Metrics.Enabled is set, on the internal Metrics.ListenAddr.
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	routing "github.com/go-ozzo/ozzo-routing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"bob-leaderboard/app/logger"
+)
+
+const namespace = "bob"
+
+// Registry holds every collector of the application.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	Submissions = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "game_result_submissions_total",
+		Help:      "Game result submissions, by result (accepted, replayed or rejected) and rejection reason.",
+	}, []string{"result", "reason"})
+
+	RankingAggregationDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ranking_aggregation_duration_seconds",
+		Help:      "Duration of the ranking aggregations, by query.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"query"})
+
+	MongoErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mongo_operation_errors_total",
+		Help:      "Failed Mongo commands, by command name.",
+	}, []string{"command"})
+
+	TrackerFetches = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "roadmap_tracker_fetches_total",
+		Help:      "Roadmap fetches from the issue tracker, by tracker and result.",
+	}, []string{"tracker", "result"})
+
+	LinearWebhooks = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "linear_webhooks_total",
+		Help:      "Linear webhook deliveries, by action and result.",
+	}, []string{"action", "result"})
+
+	RankingIndexSize = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ranking_index_results",
+		Help:      "Results ranked by the materialised ranking.",
+	})
+
+	LiveClients = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "live_clients",
+		Help:      "Clients connected to the live leaderboard updates, by transport (sse or websocket).",
+	}, []string{"transport"})
+
+	LiveUpdatesDropped = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "live_updates_dropped_total",
+		Help:      "Live leaderboard updates missed by clients which did not read them fast enough.",
+	})
+
+	ResultEventsDropped = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "result_events_dropped_total",
+		Help:      "Stored results the live feed missed because it lagged behind, each forcing a rebuild of the materialised ranking.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Result returns the result label for an error, "ok" or "error".
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// Middleware records the count and latency of every request by route.
+func Middleware(c *routing.Context) error {
+	start := time.Now()
+	response := &statusResponseWriter{ResponseWriter: c.Response, status: http.StatusOK}
+	c.Response = response
+
+	err := c.Next()
+
+	// Errors not handled further down the chain are written by the router afterwards
+	status := response.status
+	if err != nil {
+		status = http.StatusInternalServerError
+		if httpError, ok := err.(routing.HTTPError); ok {
+			status = httpError.StatusCode()
+		}
+	}
+
+	route := routeTemplate(c)
+	HTTPRequests.WithLabelValues(route, c.Request.Method, strconv.Itoa(status)).Inc()
+	HTTPRequestDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+
+	return err
+}
+
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hands the connection over for a WebSocket, recording the switch of protocols.
+func (w *statusResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("metrics: the response can not be hijacked")
+	}
+	w.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the response of the server.
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// routeTemplate finds the registered route matching the request, so paths with
+// parameters share one label. Unmatched requests are labelled "other".
+func routeTemplate(c *routing.Context) string {
+	path := strings.Split(strings.Trim(c.Request.URL.Path, "/"), "/")
+
+	for _, route := range c.Router().Routes() {
+		if route.Method() == c.Request.Method && matchRoute(route.Path(), path) {
+			return route.Path()
+		}
+	}
+	return "other"
+}
+
+func matchRoute(template string, path []string) bool {
+	segments := strings.Split(strings.Trim(template, "/"), "/")
+	for i, segment := range segments {
+		if segment == "*" {
+			return true
+		}
+		if i >= len(path) {
+			return false
+		}
+		if strings.HasPrefix(segment, "<") && strings.HasSuffix(segment, ">") {
+			continue
+		}
+		if segment != path[i] {
+			return false
+		}
+	}
+	return len(segments) == len(path)
+}
+
+// Serve exposes the registry on addr until ctx is done.
+func Serve(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("Serving metrics on http://%s/metrics", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("Metrics server error: %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"bob-leaderboard/app/metrics"
+)
+
+type Issue struct {
+	Identifier  string     `json:"identifier"`
+	Title       string     `json:"title"`
+	State       State      `json:"state"`
+	Labels      []Label    `json:"labels"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"` // Pointer to handle nil (not completed) case
+}
+
+// Label representation for simplified structure.
+type Label struct {
+	Id    string `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Color string `json:"color,omitempty"`
+}
+
+type State struct {
+	Id    string `json:"id,omitempty"`
+	Color string `json:"color,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Type  string `json:"type,omitempty"`
+}
+
+// StateGroup represents a group of issues by their state.
+type StateGroup struct {
+	Name  string  `json:"name"`
+	Color string  `json:"color"`
+	Items []Issue `json:"items"`
+}
+
+type OrganizedIssues []StateGroup
+
+// issuesData is the cached roadmap, nil until it is loaded. The groups it points to are
+// never modified, updates replace them with a modified copy under issuesMu.
+var (
+	issuesMu   sync.RWMutex
+	issuesData *OrganizedIssues
+)
+
+// roadmapVersion is bumped every time issuesData changes.
+var roadmapVersion atomic.Int64
+
+// CachedIssues returns the cached roadmap, ok is false until it is loaded. The issues must
+// not be modified.
+func CachedIssues() (issues OrganizedIssues, ok bool) {
+	issuesMu.RLock()
+	defer issuesMu.RUnlock()
+	if issuesData == nil {
+		return nil, false
+	}
+	return *issuesData, true
+}
+
+// updateIssues applies change to a copy of the cached roadmap and caches the copy when
+// change reports it changed something, so the roadmap version only moves then. It returns
+// false, without calling change, when no roadmap is cached.
+func updateIssues(change func(issues OrganizedIssues) bool) bool {
+	issuesMu.Lock()
+	defer issuesMu.Unlock()
+	if issuesData == nil {
+		return false
+	}
+
+	updated := make(OrganizedIssues, len(*issuesData))
+	for i, group := range *issuesData {
+		group.Items = append([]Issue{}, group.Items...)
+		updated[i] = group
+	}
+	if !change(updated) {
+		return true
+	}
+
+	issuesData = &updated
+	roadmapVersion.Add(1)
+	return true
+}
+
+// RoadmapVersion changes every time the roadmap is loaded or updated by a webhook, the
+// roadmap page is validated with it.
+func RoadmapVersion() int64 {
+	return roadmapVersion.Load()
+}
+
+// IssueTracker is a source of roadmap issues. Implementations are responsible for
+// fetching issues from their backend and grouping them into OrganizedIssues.
+type IssueTracker interface {
+	Name() string
+	LoadIssues(ctx context.Context) (OrganizedIssues, error)
+}
+
+// Tracker is the issue tracker used to populate the roadmap, selected by Roadmap.Tracker.
+var Tracker IssueTracker = nil
+
+func initTracker() error {
+	tracker, err := NewIssueTracker(Settings.Roadmap.Tracker)
+	if err != nil {
+		return err
+	}
+	Tracker = tracker
+	return nil
+}
+
+// NewIssueTracker creates the issue tracker with the given name using the Roadmap settings.
+func NewIssueTracker(name string) (IssueTracker, error) {
+	roadmap := Settings.Roadmap
+	switch name {
+	case "linear":
+		return &LinearTracker{
+			Endpoint: roadmap.Linear.Endpoint,
+			ApiKey:   roadmap.Linear.ApiKey,
+			TeamId:   roadmap.Linear.TeamId,
+		}, nil
+	case "github":
+		return &GitHubTracker{
+			Endpoint:      roadmap.GitHub.Endpoint,
+			Token:         roadmap.GitHub.Token,
+			Owner:         roadmap.GitHub.Owner,
+			Repo:          roadmap.GitHub.Repo,
+			ProjectNumber: roadmap.GitHub.ProjectNumber,
+		}, nil
+	case "file":
+		return &FileTracker{
+			Path: roadmap.File.Path,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown roadmap tracker %q", name)
+}
+
+func init() {
+	metrics.Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "bob",
+		Name:      "roadmap_cache_issues",
+		Help:      "Number of issues in the cached roadmap.",
+	}, func() float64 {
+		issues, _ := CachedIssues()
+		count := 0
+		for _, group := range issues {
+			count += len(group.Items)
+		}
+		return float64(count)
+	}))
+}
+
+// LoadAllIssues loads the roadmap from the tracker and caches it.
+func LoadAllIssues(ctx context.Context) error {
+	issues, err := Tracker.LoadIssues(ctx)
+	metrics.TrackerFetches.WithLabelValues(Tracker.Name(), metrics.Result(err)).Inc()
+	if err != nil {
+		return err
+	}
+
+	issuesMu.Lock()
+	defer issuesMu.Unlock()
+	issuesData = &issues
+	roadmapVersion.Add(1)
+
+	return nil
+}
+
+var StateOrder = []string{"Backlog", "Todo", "In Progress", "Done", "Canceled", "Duplicate"}
+var StateColors = []string{"#95a2b3", "#e2e2e2", "#f2c94c", "#5e6ad2", "#95a2b3", "#95a2b3"}
+
+// organizeIssues groups issues by their state name following StateOrder,
+// issues in a state that isn't part of StateOrder are dropped.
+func organizeIssues(issues []Issue) OrganizedIssues {
+	organized := make(OrganizedIssues, len(StateOrder))
+	stateIndexMap := make(map[string]int)
+	for i, stateName := range StateOrder {
+		organized[i] = StateGroup{
+			Name:  stateName,
+			Color: StateColors[i],
+			Items: []Issue{},
+		}
+		stateIndexMap[stateName] = i
+	}
+
+	for _, issue := range issues {
+		// Get the index of the current state from the map
+		index, exists := stateIndexMap[issue.State.Name]
+		if !exists {
+			// Handle unknown state; could log a warning or dynamically add new states if needed
+			continue
+		}
+
+		// Update state color if necessary
+		if organized[index].Color == "" {
+			organized[index].Color = issue.State.Color
+		}
+
+		if issue.Labels == nil {
+			issue.Labels = []Label{}
+		}
+
+		// Append the issue to the appropriate state group
+		organized[index].Items = append(organized[index].Items, issue)
+	}
+
+	return organized
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"bob-leaderboard/app"
+	"bob-leaderboard/app/logger"
+	"bob-leaderboard/db"
+)
+
+// command is an operation of the binary, e.g. `bob-leaderboard indexes -drop-stale`.
+// Every command runs after app.Init, the ones setting Validate refuse to run with an
+// invalid config and the ones setting Connect also get a database connection.
+type command struct {
+	Usage       string
+	Description string
+	Validate    bool
+	Connect     bool
+	Run         func(args []string) error
+}
+
+// commands are looked up by their first one or two arguments, so operations can be
+// grouped like `roadmap sync`. serve runs when no command is given.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"serve": {
+			Usage:       "serve",
+			Description: "run the web server (default)",
+			Validate:    true,
+			Connect:     true,
+			Run:         serveCommand,
+		},
+		"migrate": {
+			Usage:       "migrate [-dry-run]",
+			Description: "apply the pending data migrations",
+			Connect:     true,
+			Run:         migrateCommand,
+		},
+		"indexes": {
+			Usage:       "indexes [-drop-stale] [-dry-run]",
+			Description: "create the indexes declared by the models",
+			Connect:     true,
+			Run:         indexesCommand,
+		},
+		"recompute-ranks": {
+			Usage:       "recompute-ranks [-dry-run]",
+			Description: "recompute the ranking keys of every result from its wave times",
+			Connect:     true,
+			Run:         recomputeRanksCommand,
+		},
+		"rankings rebuild": {
+			Usage:       "rankings rebuild [-sample n]",
+			Description: "build the materialised ranking from scratch, checking a sample against the aggregation",
+			Connect:     true,
+			Run:         rankingsRebuildCommand,
+		},
+		"export": {
+			Usage:       "export [-format csv] -out file",
+			Description: "write the results as NDJSON or CSV, both read back by import submissions",
+			Connect:     true,
+			Run:         exportCommand,
+		},
+		"import submissions": {
+			Usage:       "import submissions [-dry-run] file",
+			Description: "validate submissions or exported results in NDJSON or CSV and insert them, skipping duplicates",
+			Connect:     true,
+			Run:         importSubmissionsCommand,
+		},
+		"ban": {
+			Usage:       "ban [-reason text] [-lift] steamId",
+			Description: "ban a player and hide their results, or lift the ban",
+			Connect:     true,
+			Run:         banCommand,
+		},
+		"roadmap sync": {
+			Usage:       "roadmap sync [-out file]",
+			Description: "fetch the roadmap from the configured tracker, optionally saving it for the file tracker",
+			Run:         roadmapSyncCommand,
+		},
+		"openapi check": {
+			Usage:       "openapi check",
+			Description: "check that openapi.json documents every api route and matches the Go types",
+			Run:         openAPICheckCommand,
+		},
+		"config check": {
+			Usage:       "config check",
+			Description: "validate the config and environment",
+			Run:         configCheckCommand,
+		},
+		"help": {
+			Usage:       "help",
+			Description: "list the commands",
+			Run:         helpCommand,
+		},
+	}
+}
+
+// findCommand returns the command named by the first arguments and the remaining arguments.
+func findCommand(args []string) (string, command, []string, error) {
+	if len(args) == 0 {
+		return "serve", commands["serve"], nil, nil
+	}
+	if len(args) > 1 {
+		name := args[0] + " " + args[1]
+		if cmd, ok := commands[name]; ok {
+			return name, cmd, args[2:], nil
+		}
+	}
+	if cmd, ok := commands[args[0]]; ok {
+		return args[0], cmd, args[1:], nil
+	}
+	if args[0] == "-h" || args[0] == "--help" {
+		return "help", commands["help"], nil, nil
+	}
+
+	helpCommand(nil)
+	return "", command{}, nil, fmt.Errorf("unknown command %q", strings.Join(args, " "))
+}
+
+// runCommand runs the command, connecting to the database first when it needs to.
+func runCommand(args []string) error {
+	name, cmd, args, err := findCommand(args)
+	if err != nil {
+		return err
+	}
+
+	if cmd.Validate {
+		if problems := app.Settings.Validate(); len(problems) > 0 {
+			for _, problem := range problems {
+				logger.Error("Invalid config: %v", problem)
+			}
+			return fmt.Errorf("%s: invalid config, found %d problem(s)", name, len(problems))
+		}
+	}
+
+	if cmd.Connect {
+		// An interrupt stops waiting for an unreachable database
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		err := db.CreateConnection(ctx,
+			app.Settings.Mongo.URI,
+			app.Settings.Mongo.Database,
+		)
+		stop()
+		if err != nil {
+			return fmt.Errorf("connecting to database: %w", err)
+		}
+		defer db.Disconnect(context.Background())
+	}
+
+	if err := cmd.Run(args); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+func helpCommand(args []string) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n\nCommands:\n", os.Args[0])
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-40s %s\n", commands[name].Usage, commands[name].Description)
+	}
+	return nil
+}
+
+func indexesCommand(args []string) error {
+	flags := flag.NewFlagSet("indexes", flag.ContinueOnError)
+	dropStale := flags.Bool("drop-stale", false, "drop indexes which no model declares")
+	dryRun := flags.Bool("dry-run", false, "only report the changes which would be made")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return syncIndexes(db.IndexSyncOptions{DropStale: *dropStale, DryRun: *dryRun})
+}
+
+func syncIndexes(opts db.IndexSyncOptions) error {
+	reports, err := db.SyncIndexes(context.Background(), opts)
+	for _, report := range reports {
+		report.Log()
+	}
+	return err
+}
+
+func migrateCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only report the pending migrations and how many documents they change")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return runMigrations(*dryRun)
+}
+
+func runMigrations(dryRun bool) error {
+	results, err := db.RunMigrations(context.Background(), dryRun)
+	for _, result := range results {
+		result.Log()
+	}
+	if err == nil && len(results) == 0 {
+		logger.Info("No pending migrations")
+	}
+	return err
+}
+
+func recomputeRanksCommand(args []string) error {
+	flags := flag.NewFlagSet("recompute-ranks", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only report how many results would change")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	result, err := db.RecomputeRankingKeys(context.Background(), *dryRun)
+	if err != nil {
+		return err
+	}
+	if result.DryRun {
+		logger.Info("Checked %d results, %d would change", result.Checked, result.Changed)
+	} else {
+		logger.Info("Checked %d results, %d changed", result.Checked, result.Changed)
+		if result.Changed > 0 {
+			logRankingRebuildHint()
+		}
+	}
+	return nil
+}
+
+func exportCommand(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := flags.String("out", "", "the file to write to")
+	var params exportParams
+	flags.StringVar(&params.Format, "format", "ndjson", "ndjson or csv")
+	flags.StringVar(&params.From, "from", "", "only the results stored from this date (2006-01-02) or RFC 3339 time")
+	flags.StringVar(&params.To, "to", "", "only the results stored before this date or time")
+	flags.StringVar(&params.Season, "season", "", "only the results stored during this season of the config")
+	flags.StringVar(&params.SteamId, "steamId", "", "only the results of this player")
+	flags.BoolVar(&params.Visible, "visible", false, "leave out the hidden results of banned players")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	// The console logger writes to stdout, so the results always go to a file
+	if *out == "" {
+		return errors.New("-out is required")
+	}
+	filter, format, details := params.filter()
+	if len(details) > 0 {
+		return fmt.Errorf("-%s %s", details[0].Field, details[0].Message)
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := newResultWriter(format, file)
+	count, err := db.ExportResults(context.Background(), filter, writer.Write)
+	if err != nil {
+		return err
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	logger.Info("Exported %d results", count)
+	return nil
+}
+
+func importSubmissionsCommand(args []string) error {
+	flags := flag.NewFlagSet("import submissions", flag.ContinueOnError)
+	format := flags.String("format", "", "ndjson or csv, csv for a .csv file by default")
+	dryRun := flags.Bool("dry-run", false, "only report what would be imported")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected the file to import")
+	}
+	if *format == "" {
+		*format = "ndjson"
+		if strings.EqualFold(filepath.Ext(flags.Arg(0)), ".csv") {
+			*format = "csv"
+		}
+	}
+	if _, ok := exportContentTypes[*format]; !ok {
+		return fmt.Errorf("unknown format %q, expected ndjson or csv", *format)
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	rows, err := newImportRows(*format, file)
+	if err != nil {
+		return err
+	}
+	report, err := importResults(context.Background(), db.NewMongoRepository(), rows, *dryRun)
+	for _, problem := range report.Errors {
+		message := problem.Message
+		for _, detail := range problem.Details {
+			message += fmt.Sprintf(", %s %s", detail.Field, detail.Message)
+		}
+		logger.Warning("Line %d: %s", problem.Line, message)
+	}
+	if err != nil {
+		if report.Imported > 0 && !*dryRun {
+			return fmt.Errorf("%w, the %d result(s) before were imported", err, report.Imported)
+		}
+		return err
+	}
+
+	verb := "Imported"
+	if *dryRun {
+		verb = "Would import"
+	}
+	logger.Info("%s %d of %d results, %d duplicate(s) skipped, %d rejected", verb, report.Imported, report.Rows, report.Duplicates, report.Rejected)
+	if report.Rejected > len(report.Errors) {
+		logger.Info("Only the first %d rejected rows are listed", len(report.Errors))
+	}
+	if report.Imported > 0 && !*dryRun {
+		logRankingRebuildHint()
+	}
+	return nil
+}
+
+func banCommand(args []string) error {
+	flags := flag.NewFlagSet("ban", flag.ContinueOnError)
+	reason := flags.String("reason", "", "why the player is banned")
+	lift := flags.Bool("lift", false, "lift the ban instead")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected the steam id of the player")
+	}
+	steamId := flags.Arg(0)
+
+	repository := db.NewMongoRepository()
+	if *lift {
+		shown, err := repository.UnbanPlayer(context.Background(), steamId)
+		if err != nil {
+			return err
+		}
+		logger.Info("Lifted the ban of %s, %d results shown again", steamId, shown)
+		if shown > 0 {
+			logRankingRebuildHint()
+		}
+		return nil
+	}
+
+	hidden, err := repository.BanPlayer(context.Background(), steamId, *reason)
+	if err != nil {
+		return err
+	}
+	logger.Info("Banned %s, %d results hidden", steamId, hidden)
+	if hidden > 0 {
+		logRankingRebuildHint()
+	}
+	return nil
+}
+
+func roadmapSyncCommand(args []string) error {
+	flags := flag.NewFlagSet("roadmap sync", flag.ContinueOnError)
+	out := flags.String("out", "", "a .yaml or .json file to save the roadmap to, for the file tracker")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if err := app.LoadAllIssues(context.Background()); err != nil {
+		return fmt.Errorf("loading issues from %s: %w", app.Tracker.Name(), err)
+	}
+	issues, _ := app.CachedIssues()
+	for _, group := range issues {
+		logger.Info("%s: %d issues", group.Name, len(group.Items))
+	}
+
+	if *out != "" {
+		if err := app.WriteIssuesFile(*out, issues); err != nil {
+			return err
+		}
+		logger.Info("Saved the roadmap from %s to %s", app.Tracker.Name(), *out)
+	}
+	return nil
+}
+
+func configCheckCommand(args []string) error {
+	problems := app.Settings.Validate()
+	for _, problem := range problems {
+		logger.Error("%v", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problem(s)", len(problems))
+	}
+
+	logger.Info("Config for profile %s is valid", app.Profile())
+	return nil
+}
+
+func openAPICheckCommand(args []string) error {
+	problems := checkOpenAPI(newRouter())
+	for _, problem := range problems {
+		logger.Error("%v", problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d difference(s) between openapi.json and the api", len(problems))
+	}
+
+	logger.Info("openapi.json matches the api")
+	return nil
+}
@@ -1,25 +1,36 @@
 package db
 
 import (
+	"context"
 	"errors"
-	"os"
+	"regexp"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 
-	"bob-leaderboard/app"
-	"bob-leaderboard/app/logger"
+	"bob-leaderboard/app/metrics"
 )
 
+var ErrGameNotFound = errors.New("game not found")
+
+// ErrInvalidFilter is wrapped by the FilterError of a malformed filter value.
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// rankingFilters are the filters of GetRankingsOptions, all of them take a string.
+var rankingFilters = []string{"steamName", "steamId", "gameId"}
+
 type GetRankingsPagination struct {
 	Page int `json:"page"`
 	Size int `json:"size"`
 }
 type GetRankingsOptions struct {
-	Filters               map[string]any `json:"filters"`
-	SortDirection         string         `json:"sortDirection"`
-	GetRankingsPagination                /*`json:",inline"`*/
+	Filters       map[string]any `json:"filters"`
+	SortDirection string         `json:"sortDirection"`
+	// Cursor continues from a cursor returned with a page instead of reading Page
+	Cursor                string `json:"cursor,omitempty"`
+	GetRankingsPagination        /*`json:",inline"`*/
 }
 
 func (o GetRankingsOptions) Validate() GetRankingsOptions {
@@ -38,6 +49,60 @@ func (o GetRankingsOptions) Validate() GetRankingsOptions {
 	return o
 }
 
+// FilterError reports a malformed filter value of GetRankingsOptions.
+type FilterError struct {
+	Filter  string
+	Message string
+}
+
+func (e *FilterError) Error() string { return e.Filter + " filter " + e.Message }
+func (e *FilterError) Unwrap() error { return ErrInvalidFilter }
+
+// CheckFilters returns an error for every malformed filter value, rather than stopping
+// at the first one, so they can all be reported at once.
+func (o GetRankingsOptions) CheckFilters() []*FilterError {
+	var problems []*FilterError
+	for _, filter := range rankingFilters {
+		value, ok := o.Filters[filter]
+		if !ok || value == nil {
+			continue
+		}
+		text, ok := value.(string)
+		if !ok {
+			problems = append(problems, &FilterError{filter, "must be a string"})
+			continue
+		}
+
+		switch filter {
+		case "steamName":
+			if _, err := regexp.Compile("(?i)" + text); err != nil {
+				problems = append(problems, &FilterError{filter, "must be a valid regular expression"})
+			}
+		case "gameId":
+			if _, err := parseGameIdFilter(text); err != nil {
+				problems = append(problems, &FilterError{filter, "must be a 24 character hex id"})
+			}
+		}
+	}
+	return problems
+}
+
+// filtersError joins the problems found by CheckFilters, it is nil when there are none.
+func (o GetRankingsOptions) filtersError() error {
+	var errs []error
+	for _, problem := range o.CheckFilters() {
+		errs = append(errs, problem)
+	}
+	return errors.Join(errs...)
+}
+
+func parseGameIdFilter(gameId string) (primitive.ObjectID, error) {
+	if gameId == "" {
+		return primitive.NilObjectID, nil
+	}
+	return primitive.ObjectIDFromHex(gameId)
+}
+
 func (o GetRankingsOptions) GetSortDirection() int {
 	if o.SortDirection == "desc" {
 		return -1
@@ -50,35 +115,36 @@ type RankingPipelineOptions struct {
 	UsePagination bool
 }
 
-func (o RankingPipelineOptions) DumpConfig() {
-	logger.Debug("Dumping ranking pipeline options")
-	logger.Debug("Filters: %v", o.Filters)
-	logger.Debug("SortDirection: %v", o.SortDirection)
-	logger.Debug("Pagination: %v", o.GetRankingsPagination)
-	logger.Debug("UsePagination: %v", o.UsePagination)
-}
-func (o RankingPipelineOptions) BuildFilters() mongo.Pipeline {
+// BuildFilters builds the match stages for the requested filters. They run against the
+// ranked results, so a filtered entry keeps its rank on the whole leaderboard.
+func (o RankingPipelineOptions) BuildFilters() (mongo.Pipeline, error) {
+	if err := o.filtersError(); err != nil {
+		return nil, err
+	}
+
 	var filteringPipeline mongo.Pipeline
 	if steamName, ok := o.Filters["steamName"].(string); ok && steamName != "" {
-		filteringPipeline = addFilterStage(filteringPipeline, "player.steamName", bson.D{{"$regex", steamName}, {"$options", "i"}})
+		filteringPipeline = addFilterStage(filteringPipeline, "results.player.steamName", bson.D{{"$regex", steamName}, {"$options", "i"}})
 	}
 	if steamId, ok := o.Filters["steamId"].(string); ok && steamId != "" {
-		filteringPipeline = addFilterStage(filteringPipeline, "player.steamId", steamId)
+		filteringPipeline = addFilterStage(filteringPipeline, "results.player.steamId", steamId)
 	}
 	if gameId, ok := o.Filters["gameId"].(string); ok && gameId != "" {
-		filteringPipeline = addGameIdFilter(filteringPipeline, gameId)
+		oid, _ := parseGameIdFilter(gameId)
+		filteringPipeline = addFilterStage(filteringPipeline, "results._id", oid)
 	}
-	return filteringPipeline
+	return filteringPipeline, nil
 }
 
 type RankingResultsItem struct {
 	ExtraGameStatsData `bson:",inline"`
 
-	Player          SteamUserData `json:"player"`
-	Ranking         int           `json:"ranking"`
-	AverageWaveTime float64       `json:"averageWaveTime"`
-	TotalGameTime   float64       `json:"totalGameTime"`
-	WavesSurvived   int           `json:"wavesSurvived"`
+	GameId          primitive.ObjectID `json:"gameId" bson:"gameId"`
+	Player          SteamUserData      `json:"player" bson:"player"`
+	Ranking         int                `json:"ranking" bson:"ranking"`
+	AverageWaveTime float64            `json:"averageWaveTime" bson:"averageWaveTime"`
+	TotalGameTime   float64            `json:"totalGameTime" bson:"totalGameTime"`
+	WavesSurvived   int                `json:"wavesSurvived" bson:"wavesSurvived"`
 }
 
 type PaginatedRankingResults struct {
@@ -86,6 +152,9 @@ type PaginatedRankingResults struct {
 	Pagination struct {
 		Max   int `json:"maxPage" bson:"max"`
 		Total int `json:"total" bson:"total"`
+		// Next and Prev are the cursors of the pages around this one, when there are any
+		Next string `json:"next,omitempty" bson:"-"`
+		Prev string `json:"prev,omitempty" bson:"-"`
 	} `json:"pagination"`
 }
 
@@ -93,6 +162,7 @@ var LeaderboardRankingAggregationSort = bson.D{
 	{"wavesSurvived", -1},   // Descending order
 	{"averageWaveTime", -1}, // Descending order
 	{"totalGameTime", 1},    // Ascending order
+	{"_id", 1},              // Ties are ranked in submission order
 }
 
 func addFilterStage(filteringPipeline mongo.Pipeline, filterKey string, match interface{}) mongo.Pipeline {
@@ -101,21 +171,10 @@ func addFilterStage(filteringPipeline mongo.Pipeline, filterKey string, match in
 	})
 }
 
-// Specific function for gameId to handle conversion and error
-func addGameIdFilter(filteringPipeline mongo.Pipeline, gameId string) mongo.Pipeline {
-	oid, err := primitive.ObjectIDFromHex(gameId)
-	if err != nil {
-		logger.Error("Error parsing game ID:", err)
-		return filteringPipeline // Optionally return error
-	}
-	return append(filteringPipeline, bson.D{
-		{"$match", bson.D{{"_id", oid}}},
-	})
-}
-
 // Split functionality into smaller, more readable parts
 func buildBasePipeline(filteringPipeline mongo.Pipeline, options RankingPipelineOptions) mongo.Pipeline {
-	basePipeline := append(filteringPipeline,
+	basePipeline := mongo.Pipeline{
+		bson.D{{"$match", bson.D{{"hidden", bson.D{{"$ne", true}}}}}},
 		bson.D{{"$sort", LeaderboardRankingAggregationSort}},
 		bson.D{{"$group", bson.D{
 			{"_id", primitive.Null{}}, {"results", bson.D{{"$push", "$$ROOT"}}}},
@@ -123,6 +182,9 @@ func buildBasePipeline(filteringPipeline mongo.Pipeline, options RankingPipeline
 		bson.D{{"$unwind", bson.D{
 			{"path", "$results"}, {"includeArrayIndex", "ranking"}},
 		}},
+	}
+	basePipeline = append(basePipeline, filteringPipeline...)
+	basePipeline = append(basePipeline,
 		bson.D{{"$sort", bson.D{
 			{"ranking", options.GetSortDirection()},
 			{"results._id", 1}, // Only exists to help mongo sort consistently
@@ -131,13 +193,17 @@ func buildBasePipeline(filteringPipeline mongo.Pipeline, options RankingPipeline
 	return basePipeline
 }
 
-func GetRankingPipeline(options RankingPipelineOptions) mongo.Pipeline {
-	filteringPipeline := options.BuildFilters()
+func GetRankingPipeline(options RankingPipelineOptions) (mongo.Pipeline, error) {
+	filteringPipeline, err := options.BuildFilters()
+	if err != nil {
+		return nil, err
+	}
 	basePipeline := buildBasePipeline(filteringPipeline, options)
 
 	projections := bson.D{{"$project", bson.D{
 		{"ranking", 1},
 		{"_id", 0},
+		{"gameId", "$results._id"},
 		{"player.steamId", "$results.player.steamId"},
 		{"player.steamName", "$results.player.steamName"},
 		{"averageWaveTime", "$results.averageWaveTime"},
@@ -188,43 +254,50 @@ func GetRankingPipeline(options RankingPipelineOptions) mongo.Pipeline {
 		finalPipeline = append(finalPipeline, projections)
 	}
 
-	dumpPipeline(finalPipeline, options)
-
-	return finalPipeline
+	return finalPipeline, nil
 }
 
-func GetRankingForGame(gameId primitive.ObjectID) (int, error) {
-	results, err := GetAllRankings(GetRankingsOptions{
+func GetRankingForGame(ctx context.Context, gameId primitive.ObjectID) (int, error) {
+	results, err := GetAllRankings(ctx, GetRankingsOptions{
 		Filters: map[string]any{"gameId": gameId.Hex()},
 	})
 	if err != nil {
 		return 0, err
 	}
 	if len(results) == 0 {
-
-		return 0, errors.New("game not found")
+		return 0, ErrGameNotFound
 	}
 	return results[0].Ranking, nil
 }
 
-func GetAllRankingsPaginated(options GetRankingsOptions) (PaginatedRankingResults, error) {
+func GetAllRankingsPaginated(ctx context.Context, options GetRankingsOptions) (PaginatedRankingResults, error) {
+	timer := prometheus.NewTimer(metrics.RankingAggregationDuration.WithLabelValues("page"))
+	defer timer.ObserveDuration()
+
 	collection := GetCollection[GameResult]()
 
 	rankingOptions := RankingPipelineOptions{options, true}
-	pipeline := GetRankingPipeline(rankingOptions)
+	pipeline, err := GetRankingPipeline(rankingOptions)
+	if err != nil {
+		return PaginatedRankingResults{}, err
+	}
 
-	return getPipelineResult(pipeline, collection)
+	return getPipelineResult(ctx, pipeline, collection)
 }
-func GetAllRankings(options GetRankingsOptions) ([]RankingResultsItem, error) {
+func GetAllRankings(ctx context.Context, options GetRankingsOptions) ([]RankingResultsItem, error) {
+	timer := prometheus.NewTimer(metrics.RankingAggregationDuration.WithLabelValues("all"))
+	defer timer.ObserveDuration()
+
 	collection := GetCollection[GameResult]()
 
 	rankingOptions := RankingPipelineOptions{options, false}
-	pipeline := GetRankingPipeline(rankingOptions)
-
-	dumpPipeline(pipeline, rankingOptions)
+	pipeline, err := GetRankingPipeline(rankingOptions)
+	if err != nil {
+		return []RankingResultsItem{}, err
+	}
 
 	var results []RankingResultsItem
-	err := collection.AggregateAll(pipeline, &results)
+	err = collection.AggregateAll(ctx, pipeline, &results)
 	if err != nil || len(results) == 0 {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return []RankingResultsItem{}, nil
@@ -235,9 +308,9 @@ func GetAllRankings(options GetRankingsOptions) ([]RankingResultsItem, error) {
 	return results, nil
 }
 
-func getPipelineResult(pipeline mongo.Pipeline, collection *Collection[GameResult]) (PaginatedRankingResults, error) {
+func getPipelineResult(ctx context.Context, pipeline mongo.Pipeline, collection *Collection[GameResult]) (PaginatedRankingResults, error) {
 	var results []PaginatedRankingResults
-	if err := collection.AggregateAll(pipeline, &results); err != nil {
+	if err := collection.AggregateAll(ctx, pipeline, &results); err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return PaginatedRankingResults{}, nil
 		}
@@ -249,26 +322,3 @@ func getPipelineResult(pipeline mongo.Pipeline, collection *Collection[GameResul
 
 	return PaginatedRankingResults{}, nil
 }
-
-func dumpPipeline(pipeline mongo.Pipeline, options RankingPipelineOptions) {
-	dumpJson := app.Config.GetBool("RankingSystem.DumpJson")
-	dumpJsonFile := app.Config.GetBool("RankingSystem.DumpJsonFile")
-	if dumpJson || dumpJsonFile {
-		jsonBytes, err := bson.MarshalExtJSONIndent(bson.M{"pipeline": pipeline}, false, false, "  ", "  ")
-		if err != nil {
-			logger.Error("Error marshaling to JSON:", err)
-		} else {
-			logger.Debug("Ranking pipeline JSON:")
-			logger.Debug(string(jsonBytes))
-		}
-
-		if dumpJsonFile {
-			if err := os.WriteFile("ranking_pipeline.json", jsonBytes, 0644); err != nil {
-				logger.Error("Error writing JSON to file:", err)
-			}
-		}
-	}
-	if app.Config.GetBool("RankingSystem.DumpOptions") {
-		options.DumpConfig()
-	}
-}
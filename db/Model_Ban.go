@@ -0,0 +1,29 @@
+package db
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Ban keeps a steam user off the leaderboard. Their results are hidden from the
+// rankings while the ban is in place and new submissions are rejected.
+type Ban struct {
+	BaseModel `bson:",inline"`
+
+	SteamId   string    `json:"steamId" bson:"steamId"`
+	Reason    string    `json:"reason" bson:"reason"`
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+}
+
+func (b Ban) GetCollectionName() string       { return "bans" }
+func (b *Ban) OnInsert(id primitive.ObjectID) { SetModelID(&b.BaseModel, id) }
+
+func (b Ban) GetIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{"steamId", 1}}, Options: options.Index().SetUnique(true)},
+	}
+}
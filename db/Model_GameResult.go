@@ -1,7 +1,12 @@
 package db
 
 import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type ExtraGameStatsData struct {
@@ -18,6 +23,9 @@ type GameResultRequestData struct {
 
 	Player SteamUserData `json:"player"`
 	Waves  []float64     `json:"waveDurations"`
+	// RunId is generated by the client for every run, a submission repeating the RunId
+	// of a stored result is answered with that result
+	RunId string `json:"runId,omitempty"`
 }
 
 type SteamUserData struct {
@@ -36,32 +44,77 @@ type GameResult struct {
 	TotalGameTime   float64 `json:"totalGameTime" bson:"totalGameTime"`
 	AverageWaveTime float64 `json:"averageWaveTime" bson:"averageWaveTime"`
 
-	Extra ExtraGameStatsData `json:",inline" bson:",inline"`
+	// Extra is written as extra by /api/v1/results/<id> and the exports, which are the only
+	// places a GameResult is written to. The deprecated routes answer with RankingResultsItem,
+	// which keeps the stats at the top level of the entries as before.
+	Extra ExtraGameStatsData `json:"extra" bson:",inline"`
+
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+
+	// Hidden results belong to banned players and are left out of the rankings
+	Hidden bool `json:"hidden,omitempty" bson:"hidden,omitempty"`
+
+	// IdempotencyKey identifies the submission of the result among those of the player,
+	// it is unique per player. It is written as runId, the submission field it is set from
+	IdempotencyKey string `json:"runId,omitempty" bson:"idempotencyKey,omitempty"`
 }
 
 func NewGameResult(data GameResultRequestData) *GameResult {
 	d := &GameResult{
-		Player:        data.Player,
-		WaveTimes:     []float64{},
-		TotalGameTime: 0,
+		Player:         data.Player,
+		CreatedAt:      time.Now().UTC(),
+		IdempotencyKey: data.RunId,
 	}
 
+	d.SetWaveTimes(data.Waves)
+	d.Extra = data.ExtraGameStatsData
+
+	return d
+}
+
+// SetWaveTimes sets the wave durations, ignoring waves which were not survived, and
+// computes the ranking keys from them.
+func (r *GameResult) SetWaveTimes(waves []float64) {
+	r.WaveTimes = []float64{}
+	r.WavesSurvived = 0
+
 	var totalWaveTime float64 = 0
-	for _, durationSeconds := range data.Waves {
+	for _, durationSeconds := range waves {
 		if durationSeconds <= 0 {
 			continue
 		}
-		d.WaveTimes = append(d.WaveTimes, durationSeconds)
-		d.WavesSurvived++
+		r.WaveTimes = append(r.WaveTimes, durationSeconds)
+		r.WavesSurvived++
 		totalWaveTime += durationSeconds
 	}
 
-	d.AverageWaveTime = totalWaveTime / float64(d.WavesSurvived)
-	d.TotalGameTime = totalWaveTime
-	d.Extra = data.ExtraGameStatsData
-
-	return d
+	r.AverageWaveTime = totalWaveTime / float64(r.WavesSurvived)
+	r.TotalGameTime = totalWaveTime
 }
 
 func (r GameResult) GetCollectionName() string       { return "results" }
 func (r *GameResult) OnInsert(id primitive.ObjectID) { SetModelID(&r.BaseModel, id) }
+
+func (r GameResult) GetIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{"wavesSurvived", -1},
+				{"averageWaveTime", -1},
+				{"totalGameTime", 1},
+				{"_id", 1},
+			},
+		},
+		{Keys: bson.D{{"wavesSurvived", -1}}},
+		{Keys: bson.D{{"averageWaveTime", -1}}},
+		{Keys: bson.D{{"totalGameTime", 1}}},
+		{Keys: bson.D{{"player.steamId", 1}}},
+		{Keys: bson.D{{"player.steamName", 1}}},
+		{Keys: bson.D{{"createdAt", 1}}},
+		{
+			Keys: bson.D{{"player.steamId", 1}, {"idempotencyKey", 1}},
+			Options: options.Index().SetUnique(true).
+				SetPartialFilterExpression(bson.M{"idempotencyKey": bson.M{"$exists": true}}),
+		},
+	}
+}
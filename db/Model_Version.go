@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// LeaderboardVersion names the version of the ranked results, bumped on every insert
+// and moderation action.
+const LeaderboardVersion = "leaderboard"
+
+// Version counts the changes of a dataset shared by every instance, the ETags of the
+// responses built from the dataset are derived from it.
+type Version struct {
+	Name      string    `json:"name" bson:"_id"`
+	Value     int64     `json:"value" bson:"value"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+}
+
+func (v Version) GetCollectionName() string { return "versions" }
+
+// BumpVersion records a change of the dataset.
+func BumpVersion(ctx context.Context, name string) error {
+	_, err := GetCollection[Version]().UpsertOne(ctx,
+		bson.M{"_id": name},
+		bson.M{
+			"$inc": bson.M{"value": 1},
+			"$set": bson.M{"updatedAt": time.Now().UTC()},
+		},
+	)
+	return err
+}
+
+// GetVersion returns the version of the dataset, 0 until it first changes.
+func GetVersion(ctx context.Context, name string) (int64, error) {
+	version, err := GetCollection[Version]().FindByID(ctx, name)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return version.Value, nil
+}
@@ -1,17 +1,38 @@
 package db
 
 import (
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Model interface {
 	GetCollectionName() string
 }
 
+// ModelWithIndexes is implemented by models declaring the indexes of their collection,
+// they are reconciled by SyncIndexes.
+type ModelWithIndexes interface {
+	Model
+	GetIndexes() []mongo.IndexModel
+}
+
 type ModelOnInsert interface {
 	OnInsert(id primitive.ObjectID)
 }
 
+// ModelOnUpdate is called with every update document sent to the model's collection,
+// before it is sent, so the model can amend it (e.g. to $set an updatedAt field).
+type ModelOnUpdate interface {
+	OnUpdate(update bson.M)
+}
+
+// ModelOnDelete is called with the filter and deleted count after documents of the
+// model were deleted.
+type ModelOnDelete interface {
+	OnDelete(filter bson.M, deletedCount int64)
+}
+
 type BaseModel struct {
 	ID primitive.ObjectID `bson:"_id,omitempty"`
 }
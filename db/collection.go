@@ -19,10 +19,13 @@ func NewCollection[T Model](col *mongo.Collection) *Collection[T] {
 }
 
 // FindByID is a method to find a document by its ID and decode it into the type T
-func (c *Collection[T]) FindByID(id interface{}) (*T, error) {
+func (c *Collection[T]) FindByID(ctx context.Context, id interface{}) (*T, error) {
+	ctx, cancel := withTimeout(ctx, OperationRead)
+	defer cancel()
+
 	var result T
 	filter := bson.M{"_id": id}
-	err := c.collection.FindOne(context.TODO(), filter).Decode(&result)
+	err := c.collection.FindOne(ctx, filter).Decode(&result)
 	if err != nil {
 		return nil, err
 	}
@@ -30,8 +33,11 @@ func (c *Collection[T]) FindByID(id interface{}) (*T, error) {
 }
 
 // InsertOne is a method to insert a new document of type T into the collection
-func (c *Collection[T]) InsertOne(doc any) (*mongo.InsertOneResult, error) {
-	result, err := c.collection.InsertOne(context.TODO(), doc)
+func (c *Collection[T]) InsertOne(ctx context.Context, doc any) (*mongo.InsertOneResult, error) {
+	ctx, cancel := withTimeout(ctx, OperationWrite)
+	defer cancel()
+
+	result, err := c.collection.InsertOne(ctx, doc)
 	if err != nil {
 		return nil, err
 	}
@@ -42,49 +48,266 @@ func (c *Collection[T]) InsertOne(doc any) (*mongo.InsertOneResult, error) {
 }
 
 // Find is a method to find documents matching the provided filter and decode them into a slice of type T
-func (c *Collection[T]) Find(filter bson.M) ([]T, error) {
+func (c *Collection[T]) Find(ctx context.Context, filter bson.M, opts ...*options.FindOptions) ([]T, error) {
+	ctx, cancel := withTimeout(ctx, OperationRead)
+	defer cancel()
+
 	var results []T
 
-	cursor, err := c.collection.Find(context.TODO(), filter)
+	cursor, err := c.collection.Find(ctx, filter, opts...)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(context.TODO())
+	defer closeCursor(ctx, cursor)
 
-	err = cursor.All(context.TODO(), &results)
+	err = cursor.All(ctx, &results)
 	if err != nil {
 		return nil, err
 	}
 
-	/*for cursor.Next(context.TODO()) {
-		var elem T
-		err := cursor.Decode(&elem)
-		if err != nil {
-			return nil, err
+	return results, nil
+}
+
+// Each is a method to call fn with the documents matching the filter one at a time, decoded
+// from the cursor as they are read instead of all at once. It is not bounded by a timeout
+// as it takes as long as fn does, ctx should bound it. It returns how many documents were
+// passed to fn, and stops at the first error fn returns.
+func (c *Collection[T]) Each(ctx context.Context, filter bson.M, fn func(*T) error, opts ...*options.FindOptions) (int, error) {
+	cursor, err := c.collection.Find(ctx, filter, opts...)
+	if err != nil {
+		return 0, err
+	}
+	defer closeCursor(ctx, cursor)
+
+	count := 0
+	for cursor.Next(ctx) {
+		var document T
+		if err := cursor.Decode(&document); err != nil {
+			return count, err
 		}
-		results = append(results, elem)
+		if err := fn(&document); err != nil {
+			return count, err
+		}
+		count++
 	}
+	return count, cursor.Err()
+}
 
-	if err := cursor.Err(); err != nil {
-		return nil, err
-	}*/
+// Aggregate runs the pipeline and returns its cursor, the caller is responsible for closing it.
+// The aggregation is bounded server side by the Aggregate operation timeout.
+func (c *Collection[T]) Aggregate(ctx context.Context, pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
+	opts = append([]*options.AggregateOptions{
+		options.Aggregate().SetMaxTime(OperationTimeout(OperationAggregate)),
+	}, opts...)
 
-	return results, nil
+	return c.collection.Aggregate(ctx, pipeline, opts...)
 }
 
-func (c *Collection[T]) Aggregate(pipeline interface{}, opts ...*options.AggregateOptions) (*mongo.Cursor, error) {
-	return c.collection.Aggregate(context.TODO(), pipeline, opts...)
-}
+func (c *Collection[T]) AggregateAll(ctx context.Context, pipeline interface{}, results interface{}, opts ...*options.AggregateOptions) error {
+	ctx, cancel := withTimeout(ctx, OperationAggregate)
+	defer cancel()
 
-func (c *Collection[T]) AggregateAll(pipeline interface{}, results interface{}, opts ...*options.AggregateOptions) error {
-	cursor, err := c.Aggregate(pipeline, opts...)
+	cursor, err := c.Aggregate(ctx, pipeline, opts...)
 	if err != nil {
 		return err
 	}
-	defer cursor.Close(context.Background())
-	if err = cursor.All(context.Background(), results); err != nil {
+	defer closeCursor(ctx, cursor)
+	if err = cursor.All(ctx, results); err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// InsertMany is a method to insert several documents of type T into the collection
+func (c *Collection[T]) InsertMany(ctx context.Context, docs []*T, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	ctx, cancel := withTimeout(ctx, OperationWrite)
+	defer cancel()
+
+	documents := make([]any, len(docs))
+	for i, doc := range docs {
+		documents[i] = doc
+	}
+
+	result, err := c.collection.InsertMany(ctx, documents, opts...)
+	if err != nil {
+		return result, err
+	}
+	for i, id := range result.InsertedIDs {
+		if doc, ok := any(docs[i]).(ModelOnInsert); ok {
+			doc.OnInsert(id.(primitive.ObjectID))
+		}
+	}
+	return result, nil
+}
+
+// UpdateByID is a method to apply an update document to the document with the given ID
+func (c *Collection[T]) UpdateByID(ctx context.Context, id interface{}, update bson.M, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	ctx, cancel := withTimeout(ctx, OperationWrite)
+	defer cancel()
+
+	c.onUpdate(update)
+
+	return c.collection.UpdateByID(ctx, id, update, opts...)
+}
+
+// UpdateMany is a method to apply an update document to every document matching the filter
+func (c *Collection[T]) UpdateMany(ctx context.Context, filter bson.M, update bson.M, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	ctx, cancel := withTimeout(ctx, OperationWrite)
+	defer cancel()
+
+	c.onUpdate(update)
+
+	return c.collection.UpdateMany(ctx, filter, update, opts...)
+}
+
+// UpsertOne is a method to update the first document matching the filter, inserting it when none matches
+func (c *Collection[T]) UpsertOne(ctx context.Context, filter bson.M, update bson.M) (*mongo.UpdateResult, error) {
+	ctx, cancel := withTimeout(ctx, OperationWrite)
+	defer cancel()
+
+	c.onUpdate(update)
+
+	return c.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+}
+
+// FindOneAndUpdate is a method to update the first document matching the filter and decode it into the type T.
+// The updated document is returned unless the options ask for the original one.
+func (c *Collection[T]) FindOneAndUpdate(ctx context.Context, filter bson.M, update bson.M, opts ...*options.FindOneAndUpdateOptions) (*T, error) {
+	ctx, cancel := withTimeout(ctx, OperationWrite)
+	defer cancel()
+
+	c.onUpdate(update)
+
+	opts = append([]*options.FindOneAndUpdateOptions{
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	}, opts...)
+
+	var result T
+	if err := c.collection.FindOneAndUpdate(ctx, filter, update, opts...).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// DeleteByID is a method to delete the document with the given ID
+func (c *Collection[T]) DeleteByID(ctx context.Context, id interface{}) (*mongo.DeleteResult, error) {
+	ctx, cancel := withTimeout(ctx, OperationWrite)
+	defer cancel()
+
+	filter := bson.M{"_id": id}
+	result, err := c.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	c.onDelete(filter, result.DeletedCount)
+	return result, nil
+}
+
+// DeleteMany is a method to delete every document matching the filter
+func (c *Collection[T]) DeleteMany(ctx context.Context, filter bson.M) (*mongo.DeleteResult, error) {
+	ctx, cancel := withTimeout(ctx, OperationWrite)
+	defer cancel()
+
+	result, err := c.collection.DeleteMany(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	c.onDelete(filter, result.DeletedCount)
+	return result, nil
+}
+
+// Count is a method to count the documents matching the filter
+func (c *Collection[T]) Count(ctx context.Context, filter bson.M, opts ...*options.CountOptions) (int64, error) {
+	ctx, cancel := withTimeout(ctx, OperationRead)
+	defer cancel()
+
+	return c.collection.CountDocuments(ctx, filter, opts...)
+}
+
+// BulkWrite is a method to run several write operations in one request. Update documents
+// given as bson.M go through the model's OnUpdate hook like the other update methods.
+func (c *Collection[T]) BulkWrite(ctx context.Context, models []mongo.WriteModel, opts ...*options.BulkWriteOptions) (*mongo.BulkWriteResult, error) {
+	ctx, cancel := withTimeout(ctx, OperationWrite)
+	defer cancel()
+
+	for _, model := range models {
+		switch model := model.(type) {
+		case *mongo.UpdateOneModel:
+			if update, ok := model.Update.(bson.M); ok {
+				c.onUpdate(update)
+			}
+		case *mongo.UpdateManyModel:
+			if update, ok := model.Update.(bson.M); ok {
+				c.onUpdate(update)
+			}
+		}
+	}
+
+	return c.collection.BulkWrite(ctx, models, opts...)
+}
+
+// Watch is a method to open a change stream on the collection, the caller is responsible
+// for closing it. It is not bounded by a timeout as change streams are long-lived.
+func (c *Collection[T]) Watch(ctx context.Context, pipeline interface{}, opts ...*options.ChangeStreamOptions) (*mongo.ChangeStream, error) {
+	return c.collection.Watch(ctx, pipeline, opts...)
+}
+
+// Page is a single page of documents returned by FindPage.
+type Page[T any] struct {
+	Data    []T   `json:"data"`
+	Page    int   `json:"page"`
+	Size    int   `json:"size"`
+	Total   int64 `json:"total"`
+	MaxPage int64 `json:"maxPage"`
+}
+
+// FindPage is a method to find one page of the documents matching the filter, ordered by sort.
+// Pages start at 1.
+func (c *Collection[T]) FindPage(ctx context.Context, filter bson.M, sort bson.D, page, size int) (*Page[T], error) {
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 {
+		size = 10
+	}
+
+	total, err := c.Count(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.Find(ctx, filter, options.Find().
+		SetSort(sort).
+		SetSkip(int64((page-1)*size)).
+		SetLimit(int64(size)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		data = []T{}
+	}
+
+	return &Page[T]{
+		Data:    data,
+		Page:    page,
+		Size:    size,
+		Total:   total,
+		MaxPage: (total + int64(size) - 1) / int64(size),
+	}, nil
+}
+
+func (c *Collection[T]) onUpdate(update bson.M) {
+	var model T
+	if hook, ok := any(&model).(ModelOnUpdate); ok {
+		hook.OnUpdate(update)
+	}
+}
+
+func (c *Collection[T]) onDelete(filter bson.M, deletedCount int64) {
+	var model T
+	if hook, ok := any(&model).(ModelOnDelete); ok {
+		hook.OnDelete(filter, deletedCount)
+	}
+}
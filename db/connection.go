@@ -2,59 +2,129 @@ package db
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 
+	"bob-leaderboard/app"
 	"bob-leaderboard/app/logger"
+	"bob-leaderboard/app/metrics"
 )
 
 var client *mongo.Client
 var database *mongo.Database
 
-func CreateConnection(uri, dbName string) {
-	var err error
-
-	ctx := context.TODO()
+// CreateConnection connects to the database and pings it, retrying with an exponential
+// backoff configured under Mongo.Connect. An error is returned when the database is
+// still unreachable after the last attempt, or when ctx is done while waiting for it.
+func CreateConnection(ctx context.Context, uri, dbName string) error {
+	if uri == "" {
+		return errors.New("Mongo.URI is not set")
+	}
+	if dbName == "" {
+		return errors.New("Mongo.Database is not set")
+	}
 
-	clientOptions := options.Client().ApplyURI(uri)
+	clientOptions := options.Client().ApplyURI(uri).SetMonitor(&event.CommandMonitor{
+		Failed: func(ctx context.Context, evt *event.CommandFailedEvent) {
+			metrics.MongoErrors.WithLabelValues(evt.CommandName).Inc()
+		},
+	})
+	if err := clientOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid Mongo.URI: %w", err)
+	}
+	hosts := strings.Join(clientOptions.Hosts, ",")
 
-	client, err = mongo.Connect(ctx, clientOptions)
+	newClient, err := mongo.Connect(ctx, clientOptions)
 	if err != nil {
-		logger.Critical("Error connecting to database: %s", err)
+		return fmt.Errorf("connecting to database at %s: %w", hosts, err)
 	}
 
+	attempts := app.Settings.Mongo.Connect.Attempts
+	backoff := app.Settings.Mongo.Connect.InitialBackoff.Duration
+	maxBackoff := app.Settings.Mongo.Connect.MaxBackoff.Duration
+
+	for attempt := 1; ; attempt++ {
+		err = ping(ctx, newClient)
+		if err == nil {
+			break
+		}
+		if attempt >= attempts {
+			newClient.Disconnect(context.Background())
+			return fmt.Errorf("database at %s is unreachable after %d attempts: %w", hosts, attempts, err)
+		}
+
+		logger.Warning("Database at %s is unreachable (attempt %d/%d), retrying in %s: %v", hosts, attempt, attempts, backoff, err)
+		retry := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			retry.Stop()
+			newClient.Disconnect(context.Background())
+			return fmt.Errorf("connecting to database at %s: %w", hosts, ctx.Err())
+		case <-retry.C:
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+
+	client = newClient
 	database = client.Database(dbName)
 
-	createIndexes(database, ctx)
-}
+	logger.Info("Connected to database %s at %s", dbName, hosts)
 
-func createIndexes(d *mongo.Database, ctx context.Context) {
-	grColl := d.Collection(GameResult{}.GetCollectionName())
+	return nil
+}
 
-	indexModels := []mongo.IndexModel{
-		{
-			Keys: bson.D{
-				{"wavesSurvived", -1},
-				{"averageWaveTime", -1},
-				{"totalGameTime", 1},
-			},
-		},
-		{Keys: bson.D{{"wavesSurvived", -1}}},
-		{Keys: bson.D{{"averageWaveTime", -1}}},
-		{Keys: bson.D{{"totalGameTime", 1}}},
-		{Keys: bson.D{{"player.steamId", 1}}},
-		{Keys: bson.D{{"player.steamName", 1}}},
+// Disconnect closes the connection to the database, it does nothing once disconnected.
+func Disconnect(ctx context.Context) error {
+	if client == nil {
+		return nil
 	}
+	err := client.Disconnect(ctx)
+	client = nil
+	return err
+}
 
-	opts := options.CreateIndexes().SetMaxTime(10 * time.Second) // Optional: specify a max time for the operation
-	for _, index := range indexModels {
-		if _, err := grColl.Indexes().CreateOne(ctx, index, opts); err != nil {
-			logger.Critical("Failed to create index: %v", err)
-		}
+// DropDatabase drops the connected database with every collection, it is meant for the
+// scratch databases of tests and benchmarks.
+func DropDatabase(ctx context.Context) error {
+	return database.Drop(ctx)
+}
+
+// ConnectionHealth is the result of a database health check.
+type ConnectionHealth struct {
+	Healthy   bool      `json:"healthy"`
+	LatencyMs float64   `json:"latencyMs"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// CheckHealth pings the database.
+func CheckHealth(ctx context.Context) ConnectionHealth {
+	health := ConnectionHealth{CheckedAt: time.Now()}
+
+	if client == nil {
+		health.Error = "not connected"
+	} else if err := ping(ctx, client); err != nil {
+		health.Error = err.Error()
+	} else {
+		health.Healthy = true
 	}
+	health.LatencyMs = float64(time.Since(health.CheckedAt).Microseconds()) / 1000
+
+	return health
+}
+
+func ping(ctx context.Context, c *mongo.Client) error {
+	ctx, cancel := withTimeout(ctx, OperationConnect)
+	defer cancel()
+
+	return c.Ping(ctx, readpref.Primary())
 }
 
 func GetCollection[T Model]() *Collection[T] {
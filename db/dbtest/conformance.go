@@ -0,0 +1,458 @@
+// Package dbtest contains the conformance suite every db.LeaderboardRepository
+// implementation has to pass, so the in-memory repository used in tests keeps
+// ranking results exactly like the Mongo ranking pipeline.
+package dbtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"bob-leaderboard/db"
+)
+
+// RepositoryFactory returns a new, empty repository for a single sub test.
+type RepositoryFactory func(t *testing.T) db.LeaderboardRepository
+
+// RunRepositoryConformance runs the shared repository suite against the repositories
+// created by newRepository.
+func RunRepositoryConformance(t *testing.T, newRepository RepositoryFactory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repo db.LeaderboardRepository)
+	}{
+		{"InsertAndFind", testInsertAndFind},
+		{"InsertMany", testInsertMany},
+		{"IdempotentSubmissions", testIdempotentSubmissions},
+		{"EmptyLeaderboard", testEmptyLeaderboard},
+		{"RankingOrder", testRankingOrder},
+		{"TiesKeepSubmissionOrder", testTiesKeepSubmissionOrder},
+		{"SortDirection", testSortDirection},
+		{"Pagination", testPagination},
+		{"CursorPagination", testCursorPagination},
+		{"FiltersKeepGlobalRank", testFiltersKeepGlobalRank},
+		{"MalformedFiltersAreRejected", testMalformedFiltersAreRejected},
+		{"RankingForGame", testRankingForGame},
+		{"PlayerLookup", testPlayerLookup},
+		{"BannedPlayersAreHidden", testBannedPlayersAreHidden},
+		{"VersionChangesWithTheLeaderboard", testVersionChangesWithTheLeaderboard},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, newRepository(t))
+		})
+	}
+}
+
+// Result builds a game result with the given ranking keys.
+func Result(steamId, steamName string, waves []float64) *db.GameResult {
+	return db.NewGameResult(db.GameResultRequestData{
+		Player: db.SteamUserData{SteamId: steamId, Name: steamName},
+		Waves:  waves,
+	})
+}
+
+func insert(t *testing.T, repo db.LeaderboardRepository, results ...*db.GameResult) []primitive.ObjectID {
+	t.Helper()
+
+	ids := make([]primitive.ObjectID, len(results))
+	for i, result := range results {
+		id, err := repo.InsertResult(context.Background(), result)
+		if err != nil {
+			t.Fatalf("InsertResult: %v", err)
+		}
+		ids[i] = id
+	}
+	return ids
+}
+
+func page(t *testing.T, repo db.LeaderboardRepository, options db.GetRankingsOptions) db.PaginatedRankingResults {
+	t.Helper()
+
+	results, err := repo.GetRankingsPage(context.Background(), options)
+	if err != nil {
+		t.Fatalf("GetRankingsPage: %v", err)
+	}
+	return results
+}
+
+func expectPlayers(t *testing.T, results db.PaginatedRankingResults, players ...string) {
+	t.Helper()
+
+	got := make([]string, len(results.Data))
+	for i, item := range results.Data {
+		got[i] = item.Player.SteamId
+	}
+	if fmt.Sprint(got) != fmt.Sprint(players) {
+		t.Fatalf("expected players %v, got %v", players, got)
+	}
+}
+
+func expectRankings(t *testing.T, results db.PaginatedRankingResults, rankings ...int) {
+	t.Helper()
+
+	got := make([]int, len(results.Data))
+	for i, item := range results.Data {
+		got[i] = item.Ranking
+	}
+	if fmt.Sprint(got) != fmt.Sprint(rankings) {
+		t.Fatalf("expected rankings %v, got %v", rankings, got)
+	}
+}
+
+func testInsertAndFind(t *testing.T, repo db.LeaderboardRepository) {
+	result := Result("1", "Alice", []float64{10, 20})
+	ids := insert(t, repo, result)
+
+	if ids[0].IsZero() || result.ID != ids[0] {
+		t.Fatalf("expected the inserted id to be set on the result, got %s / %s", ids[0].Hex(), result.ID.Hex())
+	}
+
+	found, err := repo.FindResultByID(context.Background(), ids[0])
+	if err != nil {
+		t.Fatalf("FindResultByID: %v", err)
+	}
+	if found.Player != result.Player || found.WavesSurvived != 2 || found.TotalGameTime != 30 || found.AverageWaveTime != 15 {
+		t.Fatalf("unexpected result %+v", found)
+	}
+
+	if _, err := repo.FindResultByID(context.Background(), primitive.NewObjectID()); !errors.Is(err, db.ErrGameNotFound) {
+		t.Fatalf("expected ErrGameNotFound for an unknown id, got %v", err)
+	}
+}
+
+func testInsertMany(t *testing.T, repo db.LeaderboardRepository) {
+	insert(t, repo, Result("1", "A", []float64{1, 1}))
+	before, err := repo.Version(context.Background())
+	if err != nil {
+		t.Fatalf("Version: %v", err)
+	}
+
+	results := []*db.GameResult{
+		Result("2", "B", []float64{1}),
+		Result("3", "C", []float64{1, 1, 1}),
+	}
+	if err := repo.InsertResults(context.Background(), results); err != nil {
+		t.Fatalf("InsertResults: %v", err)
+	}
+	if results[0].ID.IsZero() || results[1].ID.IsZero() || results[0].ID == results[1].ID {
+		t.Fatalf("expected the inserted ids to be set on the results, got %s / %s", results[0].ID.Hex(), results[1].ID.Hex())
+	}
+	expectPlayers(t, page(t, repo, db.GetRankingsOptions{}), "3", "1", "2")
+
+	ranking, err := repo.GetRankingForGame(context.Background(), results[1].ID)
+	if err != nil || ranking != 0 {
+		t.Fatalf("expected the inserted result to be ranked 0, got %d / %v", ranking, err)
+	}
+	if version, _ := repo.Version(context.Background()); version == before {
+		t.Fatalf("expected the version to change with the inserts")
+	}
+
+	if err := repo.InsertResults(context.Background(), nil); err != nil {
+		t.Fatalf("InsertResults without results: %v", err)
+	}
+}
+
+func testIdempotentSubmissions(t *testing.T, repo db.LeaderboardRepository) {
+	first := Result("1", "A", []float64{1})
+	first.IdempotencyKey = "run-1"
+	ids := insert(t, repo, first, Result("1", "A", []float64{1}), Result("1", "A", []float64{1}))
+
+	retry := Result("1", "A", []float64{1})
+	retry.IdempotencyKey = "run-1"
+	if _, err := repo.InsertResult(context.Background(), retry); !errors.Is(err, db.ErrDuplicateSubmission) {
+		t.Fatalf("expected ErrDuplicateSubmission for a repeated key, got %v", err)
+	}
+	other := Result("2", "B", []float64{1})
+	other.IdempotencyKey = "run-1"
+	insert(t, repo, other)
+	if total := page(t, repo, db.GetRankingsOptions{}).Pagination.Total; total != 4 {
+		t.Fatalf("expected the repeated submission to be left out, got %d results", total)
+	}
+
+	found, err := repo.FindResultBySubmission(context.Background(), "1", "run-1")
+	if err != nil || found.ID != ids[0] {
+		t.Fatalf("expected the result stored with the key, got %+v / %v", found, err)
+	}
+	if _, err := repo.FindResultBySubmission(context.Background(), "1", "run-2"); !errors.Is(err, db.ErrGameNotFound) {
+		t.Fatalf("expected ErrGameNotFound for an unknown key, got %v", err)
+	}
+}
+
+func testEmptyLeaderboard(t *testing.T, repo db.LeaderboardRepository) {
+	results := page(t, repo, db.GetRankingsOptions{})
+	if len(results.Data) != 0 || results.Pagination.Total != 0 || results.Pagination.Max != 0 {
+		t.Fatalf("expected an empty page, got %+v", results)
+	}
+}
+
+func testRankingOrder(t *testing.T, repo db.LeaderboardRepository) {
+	insert(t, repo,
+		Result("fewer-waves", "A", []float64{50, 50}),
+		Result("slow-waves", "B", []float64{10, 10, 10}),
+		Result("fast-waves", "C", []float64{20, 20, 20}),
+		Result("most-waves", "D", []float64{1, 1, 1, 1}),
+	)
+
+	results := page(t, repo, db.GetRankingsOptions{})
+	expectPlayers(t, results, "most-waves", "fast-waves", "slow-waves", "fewer-waves")
+	expectRankings(t, results, 0, 1, 2, 3)
+
+	if results.Pagination.Total != 4 || results.Pagination.Max != 1 {
+		t.Fatalf("unexpected pagination %+v", results.Pagination)
+	}
+	if results.Data[1].WavesSurvived != 3 || results.Data[1].AverageWaveTime != 20 || results.Data[1].TotalGameTime != 60 {
+		t.Fatalf("unexpected ranking entry %+v", results.Data[1])
+	}
+}
+
+func testTiesKeepSubmissionOrder(t *testing.T, repo db.LeaderboardRepository) {
+	insert(t, repo,
+		Result("first", "A", []float64{5, 5}),
+		Result("second", "B", []float64{5, 5}),
+		Result("third", "C", []float64{5, 5}),
+	)
+
+	expectPlayers(t, page(t, repo, db.GetRankingsOptions{}), "first", "second", "third")
+}
+
+func testSortDirection(t *testing.T, repo db.LeaderboardRepository) {
+	insert(t, repo,
+		Result("1", "A", []float64{1}),
+		Result("2", "B", []float64{1, 1}),
+		Result("3", "C", []float64{1, 1, 1}),
+	)
+
+	results := page(t, repo, db.GetRankingsOptions{SortDirection: "desc"})
+	expectPlayers(t, results, "1", "2", "3")
+	expectRankings(t, results, 2, 1, 0)
+}
+
+func testPagination(t *testing.T, repo db.LeaderboardRepository) {
+	for i := 1; i <= 5; i++ {
+		waves := make([]float64, i)
+		for w := range waves {
+			waves[w] = 1
+		}
+		insert(t, repo, Result(fmt.Sprint(i), "Player", waves))
+	}
+
+	options := db.GetRankingsOptions{}
+	options.GetRankingsPagination = db.GetRankingsPagination{Page: 2, Size: 2}
+
+	results := page(t, repo, options)
+	expectPlayers(t, results, "3", "2")
+	expectRankings(t, results, 2, 3)
+	if results.Pagination.Total != 5 || results.Pagination.Max != 3 {
+		t.Fatalf("unexpected pagination %+v", results.Pagination)
+	}
+
+	options.GetRankingsPagination.Page = 4
+	if results := page(t, repo, options); len(results.Data) != 0 {
+		t.Fatalf("expected no entries past the last page, got %d", len(results.Data))
+	}
+}
+
+func testCursorPagination(t *testing.T, repo db.LeaderboardRepository) {
+	insert(t, repo,
+		Result("1", "A", []float64{1, 1, 1, 1, 1}),
+		Result("2", "B", []float64{1, 1, 1, 1}),
+		Result("3", "C", []float64{1, 1, 1}),
+		Result("4", "D", []float64{1, 1}),
+		Result("5", "E", []float64{1}),
+	)
+
+	first := page(t, repo, db.GetRankingsOptions{GetRankingsPagination: db.GetRankingsPagination{Size: 2}})
+	expectPlayers(t, first, "1", "2")
+	if first.Pagination.Prev != "" || first.Pagination.Next == "" {
+		t.Fatalf("expected only a next cursor on the first page, got %+v", first.Pagination)
+	}
+
+	second := page(t, repo, db.GetRankingsOptions{Cursor: first.Pagination.Next, GetRankingsPagination: db.GetRankingsPagination{Size: 2}})
+	expectPlayers(t, second, "3", "4")
+	expectRankings(t, second, 2, 3)
+	if second.Pagination.Total != 5 || second.Pagination.Max != 3 {
+		t.Fatalf("expected the totals of the whole leaderboard, got %+v", second.Pagination)
+	}
+
+	// A result ranked before the cursor does not shift the next page
+	insert(t, repo, Result("6", "F", []float64{1, 1, 1, 1, 1, 1}))
+
+	last := page(t, repo, db.GetRankingsOptions{Cursor: second.Pagination.Next, GetRankingsPagination: db.GetRankingsPagination{Size: 2}})
+	expectPlayers(t, last, "5")
+	expectRankings(t, last, 5)
+	if last.Pagination.Next != "" || last.Pagination.Prev == "" {
+		t.Fatalf("expected only a prev cursor on the last page, got %+v", last.Pagination)
+	}
+
+	back := page(t, repo, db.GetRankingsOptions{Cursor: last.Pagination.Prev, GetRankingsPagination: db.GetRankingsPagination{Size: 2}})
+	expectPlayers(t, back, "3", "4")
+	expectRankings(t, back, 3, 4)
+
+	// In descending order the page after an entry holds the better results
+	desc := page(t, repo, db.GetRankingsOptions{Cursor: back.Pagination.Next, SortDirection: "desc", GetRankingsPagination: db.GetRankingsPagination{Size: 2}})
+	expectPlayers(t, desc, "3", "2")
+	expectRankings(t, desc, 3, 2)
+
+	_, err := repo.GetRankingsPage(context.Background(), db.GetRankingsOptions{Cursor: "not-a-cursor"})
+	if !errors.Is(err, db.ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func testFiltersKeepGlobalRank(t *testing.T, repo db.LeaderboardRepository) {
+	insert(t, repo,
+		Result("1", "Alice", []float64{1, 1, 1}),
+		Result("2", "Bob", []float64{1, 1}),
+		Result("3", "alicia", []float64{1}),
+	)
+
+	results := page(t, repo, db.GetRankingsOptions{Filters: map[string]any{"steamName": "ALIC"}})
+	expectPlayers(t, results, "1", "3")
+	expectRankings(t, results, 0, 2)
+	if results.Pagination.Total != 2 {
+		t.Fatalf("expected the filtered total to be 2, got %d", results.Pagination.Total)
+	}
+
+	results = page(t, repo, db.GetRankingsOptions{Filters: map[string]any{"steamId": "2"}})
+	expectPlayers(t, results, "2")
+	expectRankings(t, results, 1)
+}
+
+func testMalformedFiltersAreRejected(t *testing.T, repo db.LeaderboardRepository) {
+	insert(t, repo, Result("1", "Alice", []float64{1}))
+
+	for _, filters := range []map[string]any{
+		{"gameId": "not-an-id"},
+		{"steamId": 42},
+		{"steamName": "(alice"},
+	} {
+		_, err := repo.GetRankingsPage(context.Background(), db.GetRankingsOptions{Filters: filters})
+		if !errors.Is(err, db.ErrInvalidFilter) {
+			t.Errorf("filters %v: expected ErrInvalidFilter, got %v", filters, err)
+		}
+	}
+}
+
+func testRankingForGame(t *testing.T, repo db.LeaderboardRepository) {
+	ids := insert(t, repo,
+		Result("1", "A", []float64{1}),
+		Result("2", "B", []float64{1, 1, 1}),
+		Result("3", "C", []float64{1, 1}),
+	)
+
+	for i, expected := range []int{2, 0, 1} {
+		ranking, err := repo.GetRankingForGame(context.Background(), ids[i])
+		if err != nil {
+			t.Fatalf("GetRankingForGame: %v", err)
+		}
+		if ranking != expected {
+			t.Fatalf("expected game %d to be ranked %d, got %d", i, expected, ranking)
+		}
+	}
+
+	if _, err := repo.GetRankingForGame(context.Background(), primitive.NewObjectID()); !errors.Is(err, db.ErrGameNotFound) {
+		t.Fatalf("expected ErrGameNotFound for an unknown game, got %v", err)
+	}
+}
+
+func testPlayerLookup(t *testing.T, repo db.LeaderboardRepository) {
+	ids := insert(t, repo,
+		Result("1", "A", []float64{1}),
+		Result("2", "B", []float64{1, 1}),
+		Result("1", "A", []float64{1, 1, 1}),
+	)
+
+	results, err := repo.FindResultsByPlayer(context.Background(), "1")
+	if err != nil {
+		t.Fatalf("FindResultsByPlayer: %v", err)
+	}
+	if len(results) != 2 || results[0].ID != ids[0] || results[1].ID != ids[2] {
+		t.Fatalf("expected the two results of player 1 oldest first, got %+v", results)
+	}
+
+	results, err = repo.FindResultsByPlayer(context.Background(), "unknown")
+	if err != nil {
+		t.Fatalf("FindResultsByPlayer: %v", err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("expected no results for an unknown player, got %d", len(results))
+	}
+}
+
+func testBannedPlayersAreHidden(t *testing.T, repo db.LeaderboardRepository) {
+	ids := insert(t, repo,
+		Result("cheater", "A", []float64{1, 1, 1}),
+		Result("1", "B", []float64{1, 1}),
+		Result("cheater", "A", []float64{1}),
+	)
+
+	hidden, err := repo.BanPlayer(context.Background(), "cheater", "modified client")
+	if err != nil {
+		t.Fatalf("BanPlayer: %v", err)
+	}
+	if hidden != 2 {
+		t.Fatalf("expected 2 hidden results, got %d", hidden)
+	}
+	if banned, err := repo.IsBanned(context.Background(), "cheater"); err != nil || !banned {
+		t.Fatalf("expected the player to be banned, got %v / %v", banned, err)
+	}
+
+	results := page(t, repo, db.GetRankingsOptions{})
+	expectPlayers(t, results, "1")
+	expectRankings(t, results, 0)
+	if _, err := repo.GetRankingForGame(context.Background(), ids[0]); !errors.Is(err, db.ErrGameNotFound) {
+		t.Fatalf("expected ErrGameNotFound for a hidden game, got %v", err)
+	}
+
+	shown, err := repo.UnbanPlayer(context.Background(), "cheater")
+	if err != nil {
+		t.Fatalf("UnbanPlayer: %v", err)
+	}
+	if shown != 2 {
+		t.Fatalf("expected 2 results to be shown again, got %d", shown)
+	}
+	if banned, err := repo.IsBanned(context.Background(), "cheater"); err != nil || banned {
+		t.Fatalf("expected the ban to be lifted, got %v / %v", banned, err)
+	}
+	expectPlayers(t, page(t, repo, db.GetRankingsOptions{}), "cheater", "1", "cheater")
+}
+
+func testVersionChangesWithTheLeaderboard(t *testing.T, repo db.LeaderboardRepository) {
+	versions := map[string]int64{}
+	expectNewVersion := func(change string) {
+		t.Helper()
+		version, err := repo.Version(context.Background())
+		if err != nil {
+			t.Fatalf("Version: %v", err)
+		}
+		for previous, seen := range versions {
+			if seen == version {
+				t.Fatalf("the version after %s is the one after %s", change, previous)
+			}
+		}
+		versions[change] = version
+	}
+
+	expectNewVersion("nothing")
+	insert(t, repo, Result("1", "A", []float64{1}))
+	expectNewVersion("an insert")
+
+	if _, err := repo.BanPlayer(context.Background(), "1", ""); err != nil {
+		t.Fatalf("BanPlayer: %v", err)
+	}
+	expectNewVersion("a ban")
+	if _, err := repo.UnbanPlayer(context.Background(), "1"); err != nil {
+		t.Fatalf("UnbanPlayer: %v", err)
+	}
+	expectNewVersion("an unban")
+
+	page(t, repo, db.GetRankingsOptions{})
+	if version, _ := repo.Version(context.Background()); version != versions["an unban"] {
+		t.Fatalf("expected reads to keep the version %d, got %d", versions["an unban"], version)
+	}
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// RankingDiagnostics describes how the ranking pipeline runs for a set of options.
+type RankingDiagnostics struct {
+	Options  GetRankingsOptions `json:"options"`
+	Pipeline json.RawMessage    `json:"pipeline"`
+	Summary  ExplainSummary     `json:"summary"`
+	// Explain is the full output of the explain command, as relaxed extended JSON
+	Explain json.RawMessage `json:"explain"`
+}
+
+// ExplainSummary collects the figures of an explain output which matter most when
+// looking at a slow ranking query.
+type ExplainSummary struct {
+	IndexesUsed         []string `json:"indexesUsed"`
+	CollectionScan      bool     `json:"collectionScan"`
+	DocsExamined        int64    `json:"docsExamined"`
+	KeysExamined        int64    `json:"keysExamined"`
+	ExecutionTimeMillis int64    `json:"executionTimeMillis"`
+}
+
+// ExplainRankings builds the paginated ranking pipeline for the options and runs it
+// through the explain command with the executionStats verbosity.
+func ExplainRankings(ctx context.Context, options GetRankingsOptions) (RankingDiagnostics, error) {
+	options = options.Validate()
+	diagnostics := RankingDiagnostics{Options: options}
+
+	pipeline, err := GetRankingPipeline(RankingPipelineOptions{options, true})
+	if err != nil {
+		return diagnostics, err
+	}
+
+	ctx, cancel := withTimeout(ctx, OperationAggregate)
+	defer cancel()
+
+	var explain bson.M
+	err = database.RunCommand(ctx, bson.D{
+		{"explain", bson.D{
+			{"aggregate", GameResult{}.GetCollectionName()},
+			{"pipeline", pipeline},
+			{"cursor", bson.D{}},
+		}},
+		{"verbosity", "executionStats"},
+	}).Decode(&explain)
+	if err != nil {
+		return diagnostics, err
+	}
+
+	if diagnostics.Pipeline, err = marshalRelaxedJSON(bson.M{"pipeline": pipeline}, "pipeline"); err != nil {
+		return diagnostics, err
+	}
+	if diagnostics.Explain, err = bson.MarshalExtJSON(explain, false, false); err != nil {
+		return diagnostics, err
+	}
+
+	diagnostics.Summary = summarizeExplain(explain)
+
+	return diagnostics, nil
+}
+
+// marshalRelaxedJSON encodes a document as relaxed extended JSON and returns one of its fields,
+// as only documents can be encoded.
+func marshalRelaxedJSON(document bson.M, field string) (json.RawMessage, error) {
+	encoded, err := bson.MarshalExtJSON(document, false, false)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, err
+	}
+	return fields[field], nil
+}
+
+// summarizeExplain walks the explain output, whose layout depends on the server version
+// and the query engine, and collects the index names and execution statistics.
+func summarizeExplain(explain bson.M) ExplainSummary {
+	summary := ExplainSummary{IndexesUsed: []string{}}
+	indexes := map[string]bool{}
+
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch value := value.(type) {
+		case bson.M:
+			if name, ok := value["indexName"].(string); ok && !indexes[name] {
+				indexes[name] = true
+				summary.IndexesUsed = append(summary.IndexesUsed, name)
+			}
+			if value["stage"] == "COLLSCAN" {
+				summary.CollectionScan = true
+			}
+			if stats, ok := value["executionStats"].(bson.M); ok {
+				summary.DocsExamined += toInt64(stats["totalDocsExamined"])
+				summary.KeysExamined += toInt64(stats["totalKeysExamined"])
+				summary.ExecutionTimeMillis = max(summary.ExecutionTimeMillis, toInt64(stats["executionTimeMillis"]))
+			}
+			for _, child := range value {
+				walk(child)
+			}
+		case bson.A:
+			for _, child := range value {
+				walk(child)
+			}
+		}
+	}
+	walk(explain)
+
+	return summary
+}
+
+func toInt64(value interface{}) int64 {
+	switch value := value.(type) {
+	case int32:
+		return int64(value)
+	case int64:
+		return value
+	case float64:
+		return int64(value)
+	}
+	return 0
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"bob-leaderboard/app/logger"
+)
+
+// IndexReport describes how the indexes of a collection were reconciled.
+type IndexReport struct {
+	Collection string
+	// Created are the declared indexes which were missing.
+	Created []string
+	// Existing are the declared indexes already present.
+	Existing []string
+	// Stale are indexes present on the collection which no model declares.
+	Stale []string
+	// Dropped are the stale indexes which were removed.
+	Dropped []string
+}
+
+func (r IndexReport) Log() {
+	logger.Info("Indexes of %s: %d created, %d existing, %d stale, %d dropped",
+		r.Collection, len(r.Created), len(r.Existing), len(r.Stale), len(r.Dropped))
+
+	for _, name := range r.Created {
+		logger.Info("  created %s.%s", r.Collection, name)
+	}
+	for _, name := range r.Stale {
+		logger.Warning("  stale index %s.%s is not declared by any model", r.Collection, name)
+	}
+	for _, name := range r.Dropped {
+		logger.Info("  dropped %s.%s", r.Collection, name)
+	}
+}
+
+type IndexSyncOptions struct {
+	// DropStale drops indexes which no model declares instead of only reporting them.
+	DropStale bool
+	// DryRun only reports the changes which would be made.
+	DryRun bool
+}
+
+// SyncIndexes reconciles the indexes declared by every model in Models with the ones
+// present in the database: missing indexes are created in one request per collection
+// and stale ones are reported, or dropped when DropStale is set.
+func SyncIndexes(ctx context.Context, opts IndexSyncOptions) ([]IndexReport, error) {
+	var reports []IndexReport
+
+	for _, model := range Models {
+		model, ok := model.(ModelWithIndexes)
+		if !ok {
+			continue
+		}
+
+		report, err := syncModelIndexes(ctx, model, opts)
+		if err != nil {
+			return reports, fmt.Errorf("syncing indexes of %s: %w", model.GetCollectionName(), err)
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+func syncModelIndexes(ctx context.Context, model ModelWithIndexes, opts IndexSyncOptions) (IndexReport, error) {
+	ctx, cancel := withTimeout(ctx, OperationIndex)
+	defer cancel()
+
+	report := IndexReport{Collection: model.GetCollectionName()}
+	indexes := database.Collection(report.Collection).Indexes()
+
+	existing, err := indexes.ListSpecifications(ctx)
+	if err != nil {
+		return report, err
+	}
+	existingNames := make(map[string]bool, len(existing))
+	for _, spec := range existing {
+		existingNames[spec.Name] = true
+	}
+
+	declaredNames := map[string]bool{"_id_": true}
+	var missing []mongo.IndexModel
+	for _, index := range model.GetIndexes() {
+		name := IndexName(index)
+		declaredNames[name] = true
+
+		if existingNames[name] {
+			report.Existing = append(report.Existing, name)
+			continue
+		}
+
+		if index.Options == nil {
+			index.Options = options.Index()
+		}
+		index.Options.SetName(name)
+		missing = append(missing, index)
+		report.Created = append(report.Created, name)
+	}
+
+	if len(missing) > 0 && !opts.DryRun {
+		createOpts := options.CreateIndexes().SetMaxTime(OperationTimeout(OperationIndex))
+		if _, err := indexes.CreateMany(ctx, missing, createOpts); err != nil {
+			report.Created = nil
+			return report, err
+		}
+	}
+
+	for _, spec := range existing {
+		if declaredNames[spec.Name] {
+			continue
+		}
+		report.Stale = append(report.Stale, spec.Name)
+
+		if opts.DropStale && !opts.DryRun {
+			if _, err := indexes.DropOne(ctx, spec.Name); err != nil {
+				return report, err
+			}
+			report.Dropped = append(report.Dropped, spec.Name)
+		}
+	}
+
+	return report, nil
+}
+
+// IndexName returns the name of an index, either the one set in its options or the
+// default name Mongo generates from its keys (e.g. "wavesSurvived_-1_totalGameTime_1").
+func IndexName(index mongo.IndexModel) string {
+	if index.Options != nil && index.Options.Name != nil {
+		return *index.Options.Name
+	}
+
+	keys, ok := index.Keys.(bson.D)
+	if !ok {
+		return fmt.Sprint(index.Keys)
+	}
+
+	parts := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		parts = append(parts, key.Key, fmt.Sprint(key.Value))
+	}
+	return strings.Join(parts, "_")
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"bob-leaderboard/app/logger"
+)
+
+// Migration is a versioned change to the stored data. Migrations run once, in
+// version order, and are recorded in the migrations collection.
+type Migration struct {
+	Version int
+	Name    string
+	// Pending returns the number of documents the migration would change.
+	Pending func(ctx context.Context) (int64, error)
+	// Up applies the migration and returns the number of documents changed.
+	Up func(ctx context.Context) (int64, error)
+}
+
+// MigrationRecord is stored for every applied migration.
+type MigrationRecord struct {
+	BaseModel `bson:",inline"`
+
+	Version   int       `json:"version" bson:"version"`
+	Name      string    `json:"name" bson:"name"`
+	AppliedAt time.Time `json:"appliedAt" bson:"appliedAt"`
+	Affected  int64     `json:"affected" bson:"affected"`
+}
+
+func (r MigrationRecord) GetCollectionName() string { return "migrations" }
+func (r MigrationRecord) GetIndexes() []mongo.IndexModel {
+	return []mongo.IndexModel{
+		{Keys: bson.D{{"version", 1}}, Options: options.Index().SetUnique(true)},
+	}
+}
+
+const (
+	// migrationLockTTL bounds how long an instance which died while migrating keeps the
+	// other ones from migrating.
+	migrationLockTTL = 10 * time.Minute
+	// migrationLockPoll is how often an instance waiting for the migration lock tries again.
+	migrationLockPoll = time.Second
+)
+
+// MigrationLock is held by the instance applying the migrations, so instances starting
+// together apply them once.
+type MigrationLock struct {
+	Name        string    `json:"name" bson:"_id"`
+	Owner       string    `json:"owner" bson:"owner"`
+	LockedUntil time.Time `json:"lockedUntil" bson:"lockedUntil"`
+}
+
+func (l MigrationLock) GetCollectionName() string { return "migrationLocks" }
+
+// lockMigrations waits until the migration lock is free and takes it, the returned function
+// releases it.
+func lockMigrations(ctx context.Context) (func(), error) {
+	locks := GetCollection[MigrationLock]()
+	owner := primitive.NewObjectID().Hex()
+	for waiting := false; ; waiting = true {
+		now := time.Now().UTC()
+		// A held lock doesn't match, so the upsert fails on its _id
+		_, err := locks.FindOneAndUpdate(ctx,
+			bson.M{"_id": "migrations", "lockedUntil": bson.M{"$lte": now}},
+			bson.M{"$set": bson.M{"owner": owner, "lockedUntil": now.Add(migrationLockTTL)}},
+			options.FindOneAndUpdate().SetUpsert(true),
+		)
+		if err == nil {
+			return func() {
+				ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), OperationTimeout(OperationWrite))
+				defer cancel()
+				if _, err := locks.DeleteMany(ctx, bson.M{"_id": "migrations", "owner": owner}); err != nil {
+					logger.Warning("Error releasing the migration lock: %v", err)
+				}
+			}, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, fmt.Errorf("taking the migration lock: %w", err)
+		}
+
+		if !waiting {
+			logger.Info("Waiting for another instance to apply the migrations")
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(migrationLockPoll):
+		}
+	}
+}
+
+// MigrationResult describes a migration which was applied, or would be on a dry run.
+type MigrationResult struct {
+	Migration Migration
+	Affected  int64
+	DryRun    bool
+}
+
+func (r MigrationResult) Log() {
+	if r.DryRun {
+		logger.Info("Would apply migration %d %s: %d documents to change", r.Migration.Version, r.Migration.Name, r.Affected)
+		return
+	}
+	logger.Info("Applied migration %d %s: %d documents changed", r.Migration.Version, r.Migration.Name, r.Affected)
+}
+
+// RunMigrations applies every migration which hasn't been recorded yet, in version order.
+// With dryRun set nothing is changed and the results report the pending document counts.
+// Otherwise the migration lock is held meanwhile, so concurrent instances wait for each
+// other and see the migrations applied by the others.
+func RunMigrations(ctx context.Context, dryRun bool) ([]MigrationResult, error) {
+	migrations, err := sortedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun {
+		unlock, err := lockMigrations(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	records := GetCollection[MigrationRecord]()
+	applied, err := records.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	appliedVersions := make(map[int]bool, len(applied))
+	for _, record := range applied {
+		appliedVersions[record.Version] = true
+	}
+
+	var results []MigrationResult
+	for _, migration := range migrations {
+		if appliedVersions[migration.Version] {
+			continue
+		}
+
+		if dryRun {
+			pending, err := migration.Pending(ctx)
+			if err != nil {
+				return results, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+			}
+			results = append(results, MigrationResult{Migration: migration, Affected: pending, DryRun: true})
+			continue
+		}
+
+		affected, err := migration.Up(ctx)
+		if err != nil {
+			return results, fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+
+		_, err = records.InsertOne(ctx, &MigrationRecord{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: time.Now().UTC(),
+			Affected:  affected,
+		})
+		if err != nil {
+			return results, fmt.Errorf("recording migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+
+		results = append(results, MigrationResult{Migration: migration, Affected: affected})
+		if affected > 0 {
+			bumpLeaderboardVersion(ctx)
+		}
+	}
+
+	return results, nil
+}
+
+func sortedMigrations() ([]Migration, error) {
+	migrations := append([]Migration{}, Migrations...)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+
+	return migrations, nil
+}
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Migrations are every data migration of the application. Append new ones with
+// the next version number, never change or reorder an applied one.
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "backfill-result-created-at",
+		Pending: func(ctx context.Context) (int64, error) {
+			return GetCollection[GameResult]().Count(ctx, resultsWithoutCreatedAt)
+		},
+		Up: func(ctx context.Context) (int64, error) {
+			ctx, cancel := withTimeout(ctx, OperationAggregate)
+			defer cancel()
+
+			// Results submitted before createdAt existed get the time encoded in their ObjectID
+			result, err := GetCollection[GameResult]().collection.UpdateMany(ctx, resultsWithoutCreatedAt, mongo.Pipeline{
+				bson.D{{"$set", bson.D{{"createdAt", bson.D{{"$toDate", "$_id"}}}}}},
+			})
+			if err != nil {
+				return 0, err
+			}
+			return result.ModifiedCount, nil
+		},
+	},
+}
+
+var resultsWithoutCreatedAt = bson.M{"createdAt": bson.M{"$exists": false}}
@@ -0,0 +1,43 @@
+package db_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"bob-leaderboard/db"
+)
+
+func TestConcurrentMigrationsApplyOnce(t *testing.T) {
+	connectTestDatabase(t, "migrations")
+	migrations := db.Migrations
+	t.Cleanup(func() { db.Migrations = migrations })
+
+	var applied atomic.Int64
+	db.Migrations = []db.Migration{{
+		Version: 1,
+		Name:    "count",
+		Pending: func(ctx context.Context) (int64, error) { return 1, nil },
+		Up: func(ctx context.Context) (int64, error) {
+			applied.Add(1)
+			return 0, nil
+		},
+	}}
+
+	var instances sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		instances.Add(1)
+		go func() {
+			defer instances.Done()
+			if _, err := db.RunMigrations(context.Background(), false); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	instances.Wait()
+
+	if applied.Load() != 1 {
+		t.Errorf("the migration was applied %d times, want once", applied.Load())
+	}
+}
@@ -0,0 +1,11 @@
+package db
+
+// Models lists every model stored by the application, it is used by the
+// maintenance tasks which work across collections such as SyncIndexes.
+var Models = []Model{
+	GameResult{},
+	Ban{},
+	MigrationRecord{},
+	MigrationLock{},
+	Version{},
+}
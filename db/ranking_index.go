@@ -0,0 +1,355 @@
+package db
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// rankingIndexMaxLevel bounds the levels of the skip list, enough for 4^16 results.
+const rankingIndexMaxLevel = 16
+
+// RankingIndex is a materialised ranking of the visible results, an indexable skip list
+// ordered like LeaderboardRankingAggregationSort. Adding, removing and ranking a result
+// or finding the entry at a ranking take O(log n), a page then reads its entries in order.
+type RankingIndex struct {
+	mu     sync.RWMutex
+	head   *rankingNode
+	level  int
+	length int
+	nodes  map[primitive.ObjectID]*rankingNode
+	random *rand.Rand
+}
+
+type rankingNode struct {
+	key   rankingKey
+	entry RankingResultsItem
+	next  []rankingLink
+}
+
+// rankingLink points to the next node of a level, span being how many entries it skips
+// over, the next node included. The last link of a level spans to the end of the list.
+type rankingLink struct {
+	node *rankingNode
+	span int
+}
+
+func NewRankingIndex() *RankingIndex {
+	return &RankingIndex{
+		head:   &rankingNode{next: make([]rankingLink, rankingIndexMaxLevel)},
+		level:  1,
+		nodes:  map[primitive.ObjectID]*rankingNode{},
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Len returns the number of ranked results.
+func (x *RankingIndex) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.length
+}
+
+// Add ranks a stored result, or ranks it again when its ranking keys changed. Hidden
+// results are removed instead.
+func (x *RankingIndex) Add(result *GameResult) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if node, ok := x.nodes[result.ID]; ok {
+		if !result.Hidden && node.key == result.rankingKey() {
+			node.entry = newRankingResultsItem(result, 0)
+			return
+		}
+		x.remove(node)
+	}
+	if !result.Hidden {
+		x.insert(result)
+	}
+}
+
+// Remove stops ranking a result, and reports whether it was ranked.
+func (x *RankingIndex) Remove(id primitive.ObjectID) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	node, ok := x.nodes[id]
+	if ok {
+		x.remove(node)
+	}
+	return ok
+}
+
+// Ranking returns the 0-based ranking of a result, and whether it is ranked.
+func (x *RankingIndex) Ranking(id primitive.ObjectID) (int, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	node, ok := x.nodes[id]
+	if !ok {
+		return 0, false
+	}
+	return x.countBefore(node.key), true
+}
+
+// Entry returns the ranked entry of a result.
+func (x *RankingIndex) Entry(id primitive.ObjectID) (RankingResultsItem, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	node, ok := x.nodes[id]
+	if !ok {
+		return RankingResultsItem{}, false
+	}
+	entry := node.entry
+	entry.Ranking = x.countBefore(node.key)
+	return entry, true
+}
+
+// Entries returns up to count entries starting at the ranking from, best first.
+func (x *RankingIndex) Entries(from, count int) []RankingResultsItem {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.entries(from, count)
+}
+
+func (x *RankingIndex) entries(from, count int) []RankingResultsItem {
+	entries := []RankingResultsItem{}
+	if from < 0 || from >= x.length {
+		return entries
+	}
+	node := x.nodeAt(from)
+	for ranking := from; node != nil && len(entries) < count; ranking++ {
+		entry := node.entry
+		entry.Ranking = ranking
+		entries = append(entries, entry)
+		node = node.next[0].node
+	}
+	return entries
+}
+
+// Page returns a page of the whole leaderboard, by page number or next to the cursor of
+// the options, the filters are left to the caller.
+func (x *RankingIndex) Page(options GetRankingsOptions) (PaginatedRankingResults, error) {
+	var cursor RankingCursor
+	if options.Cursor != "" {
+		parsed, err := ParseRankingCursor(options.Cursor)
+		if err != nil {
+			return PaginatedRankingResults{}, err
+		}
+		cursor = parsed
+	}
+
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	page := PaginatedRankingResults{Data: []RankingResultsItem{}}
+	if x.length == 0 {
+		return page, nil
+	}
+
+	total, size, direction := x.length, options.GetRankingsPagination.Size, options.GetSortDirection()
+	page.Pagination.Total = total
+	page.Pagination.Max = int(math.Ceil(float64(total) / float64(size)))
+
+	// start and end delimit the page in the order it is read in, best first when ascending
+	var start, end int
+	var hasPrev, hasNext bool
+	if options.Cursor == "" {
+		start = (options.GetRankingsPagination.Page - 1) * size
+		end = min(start+size, total)
+		hasPrev, hasNext = options.Page > 1, options.Page < page.Pagination.Max
+	} else {
+		// at is where the cursor falls in the reading order, on its entry when it is ranked
+		before := x.countBefore(cursor.Key)
+		node, ranked := x.nodes[cursor.Key.ID]
+		ranked = ranked && node.key == cursor.Key
+		at := before
+		if direction < 0 {
+			at = total - before
+			if ranked {
+				at--
+			}
+		}
+
+		start, end = at, at+size
+		if ranked {
+			start, end = start+1, end+1
+		}
+		if cursor.Before {
+			start, end = max(at-size, 0), at
+		}
+		end = min(end, total)
+
+		hasMore := end < total
+		if cursor.Before {
+			hasMore = start > 0
+		}
+		hasPrev, hasNext = cursorNeighbours(cursor, hasMore)
+	}
+
+	if start < end {
+		if direction < 0 {
+			page.Data = x.entries(total-end, end-start)
+			reverseResults(page.Data)
+		} else {
+			page.Data = x.entries(start, end-start)
+		}
+	}
+	page.setCursors(hasPrev, hasNext)
+	return page, nil
+}
+
+// Replace ranks the results from scratch, dropping everything ranked before.
+func (x *RankingIndex) Replace(results []GameResult) {
+	visible := make([]*GameResult, 0, len(results))
+	for i := range results {
+		if !results[i].Hidden {
+			visible = append(visible, &results[i])
+		}
+	}
+	sort.Slice(visible, func(i, j int) bool {
+		return compareRanking(visible[i], visible[j]) < 0
+	})
+
+	// The new list is built aside so the current one keeps being read meanwhile
+	rebuilt := NewRankingIndex()
+	rebuilt.appendSorted(visible)
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.head, x.level, x.length, x.nodes = rebuilt.head, rebuilt.level, rebuilt.length, rebuilt.nodes
+}
+
+// appendSorted builds the list from results already in ranking order in O(n).
+func (x *RankingIndex) appendSorted(results []*GameResult) {
+	var last [rankingIndexMaxLevel]*rankingNode
+	var lastPosition [rankingIndexMaxLevel]int
+	for i := range last {
+		last[i] = x.head
+	}
+
+	for _, result := range results {
+		if _, ok := x.nodes[result.ID]; ok {
+			continue
+		}
+		position := x.length + 1
+		node := x.newNode(result)
+		for level := range node.next {
+			last[level].next[level] = rankingLink{node, position - lastPosition[level]}
+			last[level], lastPosition[level] = node, position
+		}
+		x.level = max(x.level, len(node.next))
+		x.length = position
+	}
+
+	for level := 0; level < x.level; level++ {
+		last[level].next[level].span = x.length - lastPosition[level]
+	}
+}
+
+func (x *RankingIndex) newNode(result *GameResult) *rankingNode {
+	level := 1
+	for level < rankingIndexMaxLevel && x.random.Intn(4) == 0 {
+		level++
+	}
+	node := &rankingNode{
+		key:   result.rankingKey(),
+		entry: newRankingResultsItem(result, 0),
+		next:  make([]rankingLink, level),
+	}
+	x.nodes[result.ID] = node
+	return node
+}
+
+func (x *RankingIndex) insert(result *GameResult) {
+	var update [rankingIndexMaxLevel]*rankingNode
+	var position [rankingIndexMaxLevel]int
+
+	key := result.rankingKey()
+	current := x.head
+	for level := x.level - 1; level >= 0; level-- {
+		if level < x.level-1 {
+			position[level] = position[level+1]
+		}
+		for next := current.next[level]; next.node != nil && next.node.key.compare(key) < 0; next = current.next[level] {
+			position[level] += next.span
+			current = next.node
+		}
+		update[level] = current
+	}
+
+	node := x.newNode(result)
+	if len(node.next) > x.level {
+		for level := x.level; level < len(node.next); level++ {
+			update[level], position[level] = x.head, 0
+			x.head.next[level].span = x.length
+		}
+		x.level = len(node.next)
+	}
+
+	for level := range node.next {
+		previous := &update[level].next[level]
+		node.next[level] = rankingLink{previous.node, previous.span - (position[0] - position[level])}
+		*previous = rankingLink{node, position[0] - position[level] + 1}
+	}
+	for level := len(node.next); level < x.level; level++ {
+		update[level].next[level].span++
+	}
+	x.length++
+}
+
+func (x *RankingIndex) remove(node *rankingNode) {
+	current := x.head
+	for level := x.level - 1; level >= 0; level-- {
+		for next := current.next[level]; next.node != nil && next.node.key.compare(node.key) < 0; next = current.next[level] {
+			current = next.node
+		}
+		link := &current.next[level]
+		if link.node == node {
+			*link = rankingLink{node.next[level].node, link.span + node.next[level].span - 1}
+		} else {
+			link.span--
+		}
+	}
+
+	for x.level > 1 && x.head.next[x.level-1].node == nil {
+		x.head.next[x.level-1].span = 0
+		x.level--
+	}
+	delete(x.nodes, node.key.ID)
+	x.length--
+}
+
+// countBefore returns how many entries are ranked before the key.
+func (x *RankingIndex) countBefore(key rankingKey) int {
+	count := 0
+	current := x.head
+	for level := x.level - 1; level >= 0; level-- {
+		for next := current.next[level]; next.node != nil && next.node.key.compare(key) < 0; next = current.next[level] {
+			count += next.span
+			current = next.node
+		}
+	}
+	return count
+}
+
+// nodeAt returns the node at the 0-based ranking, which must be ranked.
+func (x *RankingIndex) nodeAt(ranking int) *rankingNode {
+	traversed := 0
+	current := x.head
+	for level := x.level - 1; level >= 0; level-- {
+		for next := current.next[level]; next.node != nil && traversed+next.span <= ranking+1; next = current.next[level] {
+			traversed += next.span
+			current = next.node
+		}
+		if traversed == ranking+1 {
+			return current
+		}
+	}
+	return nil
+}
@@ -0,0 +1,367 @@
+package db_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"bob-leaderboard/db"
+)
+
+// rankingIndexModel is the reference the index is compared against: the ranked results
+// kept in a map and sorted on every check.
+type rankingIndexModel map[primitive.ObjectID]db.GameResult
+
+func (m rankingIndexModel) sorted() []db.GameResult {
+	sorted := make([]db.GameResult, 0, len(m))
+	for _, result := range m {
+		sorted = append(sorted, result)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		switch {
+		case a.WavesSurvived != b.WavesSurvived:
+			return a.WavesSurvived > b.WavesSurvived
+		case a.AverageWaveTime != b.AverageWaveTime:
+			return a.AverageWaveTime > b.AverageWaveTime
+		case a.TotalGameTime != b.TotalGameTime:
+			return a.TotalGameTime < b.TotalGameTime
+		}
+		return bytes.Compare(a.ID[:], b.ID[:]) < 0
+	})
+	return sorted
+}
+
+// tiedResult generates a result whose waves are drawn from a few durations, so many
+// results tie on their waves and times and are only ordered by their id.
+func tiedResult(random *rand.Rand) db.GameResult {
+	waves := make([]float64, 1+random.Intn(4))
+	for i := range waves {
+		waves[i] = float64(10 * (1 + random.Intn(3)))
+	}
+	result := db.NewGameResult(db.GameResultRequestData{
+		Player: db.SteamUserData{SteamId: strconv.Itoa(random.Intn(50)), Name: "player"},
+		Waves:  waves,
+	})
+	result.ID = primitive.NewObjectID()
+	return *result
+}
+
+// checkRankingIndex compares the rankings, entries and pages of the index with the sorted
+// results of the model.
+func checkRankingIndex(t *testing.T, index *db.RankingIndex, model rankingIndexModel) {
+	t.Helper()
+	sorted := model.sorted()
+	if index.Len() != len(sorted) {
+		t.Fatalf("Len = %d, want %d", index.Len(), len(sorted))
+	}
+
+	for i, result := range sorted {
+		if ranking, ok := index.Ranking(result.ID); !ok || ranking != i {
+			t.Fatalf("Ranking(%s) = %d, %v, want %d", result.ID.Hex(), ranking, ok, i)
+		}
+	}
+	expectEntries(t, "Entries", index.Entries(0, len(sorted)+1), sorted, 0, 1)
+
+	for _, size := range []int{1, 7, 10} {
+		pages := (len(sorted) + size - 1) / size
+		for _, direction := range []string{"asc", "desc"} {
+			for number := 1; number <= pages+1; number++ {
+				options := db.GetRankingsOptions{SortDirection: direction}
+				options.Page, options.Size = number, size
+				page, err := index.Page(options)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if page.Pagination.Total != len(sorted) || page.Pagination.Max != pages {
+					t.Fatalf("page %d of %d %s: pagination = %+v, want %d pages of %d", number, size, direction, page.Pagination, pages, len(sorted))
+				}
+
+				from, to := (number-1)*size, min(number*size, len(sorted))
+				step := 1
+				if direction == "desc" {
+					from, to, step = len(sorted)-1-from, len(sorted)-1-to, -1
+				}
+				expectEntries(t, fmt.Sprintf("page %d of %d %s", number, size, direction), page.Data, sorted, from, step)
+				if want := max(to-from, from-to, 0); len(page.Data) != want && number <= pages {
+					t.Fatalf("page %d of %d %s holds %d entries, want %d", number, size, direction, len(page.Data), want)
+				}
+			}
+		}
+	}
+
+	// Walking the cursors reads every entry once
+	var walked []db.RankingResultsItem
+	options := db.GetRankingsOptions{}
+	options.Page, options.Size = 1, 7
+	for {
+		page, err := index.Page(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		walked = append(walked, page.Data...)
+		if page.Pagination.Next == "" {
+			break
+		}
+		options.Cursor = page.Pagination.Next
+	}
+	if len(walked) != len(sorted) {
+		t.Fatalf("the cursors walked %d entries, want %d", len(walked), len(sorted))
+	}
+	expectEntries(t, "cursor walk", walked, sorted, 0, 1)
+}
+
+// expectEntries checks that the entries are the sorted results read from the ranking from,
+// one step at a time.
+func expectEntries(t *testing.T, name string, entries []db.RankingResultsItem, sorted []db.GameResult, from, step int) {
+	t.Helper()
+	for i, entry := range entries {
+		ranking := from + i*step
+		if ranking < 0 || ranking >= len(sorted) {
+			t.Fatalf("%s: entry %d is past the end of the ranking", name, i)
+		}
+		if entry.GameId != sorted[ranking].ID || entry.Ranking != ranking {
+			t.Fatalf("%s: entry %d is %s ranked %d, want %s ranked %d", name, i, entry.GameId.Hex(), entry.Ranking, sorted[ranking].ID.Hex(), ranking)
+		}
+	}
+}
+
+func TestRankingIndexMatchesSortedResults(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	index := db.NewRankingIndex()
+	model := rankingIndexModel{}
+	checkRankingIndex(t, index, model)
+
+	initial := make([]db.GameResult, 300)
+	for i := range initial {
+		initial[i] = tiedResult(random)
+		initial[i].Hidden = i%10 == 0
+		if !initial[i].Hidden {
+			model[initial[i].ID] = initial[i]
+		}
+	}
+	index.Replace(initial)
+	checkRankingIndex(t, index, model)
+
+	ids := func() []primitive.ObjectID {
+		ids := make([]primitive.ObjectID, 0, len(model))
+		for id := range model {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+		return ids
+	}
+
+	for round := 0; round < 20; round++ {
+		for op := 0; op < 40; op++ {
+			ranked := ids()
+			switch operation := random.Intn(4); {
+			case operation == 0 || len(ranked) == 0:
+				result := tiedResult(random)
+				index.Add(&result)
+				model[result.ID] = result
+			case operation == 1:
+				// Ranked again with new waves
+				result := tiedResult(random)
+				result.ID = ranked[random.Intn(len(ranked))]
+				index.Add(&result)
+				model[result.ID] = result
+			case operation == 2:
+				result := model[ranked[random.Intn(len(ranked))]]
+				result.Hidden = true
+				index.Add(&result)
+				delete(model, result.ID)
+			default:
+				id := ranked[random.Intn(len(ranked))]
+				if !index.Remove(id) {
+					t.Fatalf("Remove(%s) = false for a ranked result", id.Hex())
+				}
+				delete(model, id)
+			}
+		}
+		checkRankingIndex(t, index, model)
+	}
+
+	if index.Remove(primitive.NewObjectID()) {
+		t.Error("Remove = true for a result which is not ranked")
+	}
+
+	// Replacing drops everything ranked before
+	replaced := rankingIndexModel{}
+	results := make([]db.GameResult, 0, len(model))
+	for _, result := range model {
+		if random.Intn(2) == 0 {
+			results = append(results, result)
+			replaced[result.ID] = result
+		}
+	}
+	index.Replace(results)
+	checkRankingIndex(t, index, replaced)
+}
+
+// benchmarkSizes are the numbers of results the rankings are benchmarked with.
+var benchmarkSizes = []struct {
+	name  string
+	count int
+}{
+	{"10k", 10_000},
+	{"100k", 100_000},
+	{"1M", 1_000_000},
+}
+
+var (
+	benchmarkResultsMu sync.Mutex
+	benchmarkResults   []db.GameResult
+)
+
+// generatedResults returns count generated results, the same ones for every benchmark.
+func generatedResults(count int) []db.GameResult {
+	benchmarkResultsMu.Lock()
+	defer benchmarkResultsMu.Unlock()
+
+	random := rand.New(rand.NewSource(int64(len(benchmarkResults))))
+	for n := len(benchmarkResults); n < count; n++ {
+		benchmarkResults = append(benchmarkResults, *generatedResult(random, n))
+	}
+	return benchmarkResults[:count]
+}
+
+// generatedResult is a result of one of 5000 players, with 1 to 40 waves of 20 to 120s.
+func generatedResult(random *rand.Rand, n int) *db.GameResult {
+	result := db.NewGameResult(db.GameResultRequestData{
+		Player: db.SteamUserData{SteamId: strconv.Itoa(n % 5000), Name: fmt.Sprintf("player %d", n%5000)},
+		Waves:  generatedWaves(random),
+	})
+	result.ID = primitive.NewObjectID()
+	return result
+}
+
+func generatedWaves(random *rand.Rand) []float64 {
+	waves := make([]float64, 1+random.Intn(40))
+	for i := range waves {
+		waves[i] = 20 + random.Float64()*100
+	}
+	return waves
+}
+
+// rankingIndexOf returns an index ranking the first count generated results.
+func rankingIndexOf(count int) (*db.RankingIndex, []db.GameResult) {
+	results := generatedResults(count)
+	index := db.NewRankingIndex()
+	index.Replace(results)
+	return index, results
+}
+
+func BenchmarkRankingIndexReplace(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		results := generatedResults(size.count)
+		b.Run(size.name, func(b *testing.B) {
+			index := db.NewRankingIndex()
+			for i := 0; i < b.N; i++ {
+				index.Replace(results)
+			}
+		})
+	}
+}
+
+func BenchmarkRankingIndexPage(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		index, results := rankingIndexOf(size.count)
+		for _, page := range []struct {
+			name   string
+			number int
+		}{{"first", 1}, {"middle", len(results) / 20}} {
+			options := db.GetRankingsOptions{}
+			options.Page, options.Size = page.number, 10
+			b.Run(size.name+"/"+page.name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := index.Page(options); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkRankingIndexRanking(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		index, results := rankingIndexOf(size.count)
+		b.Run(size.name, func(b *testing.B) {
+			random := rand.New(rand.NewSource(1))
+			for i := 0; i < b.N; i++ {
+				index.Ranking(results[random.Intn(len(results))].ID)
+			}
+		})
+	}
+}
+
+func BenchmarkRankingIndexAdd(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		index, results := rankingIndexOf(size.count)
+		b.Run(size.name, func(b *testing.B) {
+			random := rand.New(rand.NewSource(1))
+			for i := 0; i < b.N; i++ {
+				// Ranks a stored result again, with new waves
+				result := db.NewGameResult(db.GameResultRequestData{Waves: generatedWaves(random)})
+				result.ID = results[random.Intn(len(results))].ID
+				index.Add(result)
+			}
+		})
+	}
+}
+
+// BenchmarkAggregation reads the same pages and rankings as the RankingIndex benchmarks
+// with the ranking aggregation, from generated results stored in the test database.
+func BenchmarkAggregation(b *testing.B) {
+	connectTestDatabase(b, "bench")
+	ctx := context.Background()
+	repository := db.NewMongoRepository()
+
+	stored := 0
+	for _, size := range benchmarkSizes {
+		results := generatedResults(size.count)
+		for stored < len(results) {
+			batch := make([]*db.GameResult, 0, 10_000)
+			for _, result := range results[stored:min(stored+10_000, len(results))] {
+				result := result
+				batch = append(batch, &result)
+			}
+			if err := repository.InsertResults(ctx, batch); err != nil {
+				b.Fatal(err)
+			}
+			stored += len(batch)
+		}
+
+		for _, page := range []struct {
+			name   string
+			number int
+		}{{"first", 1}, {"middle", len(results) / 20}} {
+			options := db.GetRankingsOptions{}
+			options.Page, options.Size = page.number, 10
+			b.Run(size.name+"/"+page.name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := repository.GetRankingsPage(ctx, options); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+
+		b.Run(size.name+"/ranking", func(b *testing.B) {
+			random := rand.New(rand.NewSource(1))
+			for i := 0; i < b.N; i++ {
+				if _, err := repository.GetRankingForGame(ctx, results[random.Intn(len(results))].ID); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
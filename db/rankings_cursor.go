@@ -0,0 +1,290 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"bob-leaderboard/app/metrics"
+)
+
+// ErrInvalidCursor is returned for a cursor which was not returned with a rankings page.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// rankingKey is what results are ranked by, in the order of LeaderboardRankingAggregationSort.
+type rankingKey struct {
+	WavesSurvived   int                `json:"w"`
+	AverageWaveTime float64            `json:"a"`
+	TotalGameTime   float64            `json:"t"`
+	ID              primitive.ObjectID `json:"i"`
+}
+
+func (r *GameResult) rankingKey() rankingKey {
+	return rankingKey{r.WavesSurvived, r.AverageWaveTime, r.TotalGameTime, r.ID}
+}
+
+func (i RankingResultsItem) rankingKey() rankingKey {
+	return rankingKey{i.WavesSurvived, i.AverageWaveTime, i.TotalGameTime, i.GameId}
+}
+
+// compare is negative when k is ranked before other.
+func (k rankingKey) compare(other rankingKey) int {
+	switch {
+	case k.WavesSurvived != other.WavesSurvived:
+		return other.WavesSurvived - k.WavesSurvived
+	case k.AverageWaveTime > other.AverageWaveTime:
+		return -1
+	case k.AverageWaveTime < other.AverageWaveTime:
+		return 1
+	case k.TotalGameTime < other.TotalGameTime:
+		return -1
+	case k.TotalGameTime > other.TotalGameTime:
+		return 1
+	}
+	return bytes.Compare(k.ID[:], other.ID[:])
+}
+
+// rankedAfter matches the results ranked after the key, it is served by the compound
+// ranking index.
+func (k rankingKey) rankedAfter() bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"wavesSurvived": bson.M{"$lt": k.WavesSurvived}},
+		bson.M{"wavesSurvived": k.WavesSurvived, "averageWaveTime": bson.M{"$lt": k.AverageWaveTime}},
+		bson.M{"wavesSurvived": k.WavesSurvived, "averageWaveTime": k.AverageWaveTime, "totalGameTime": bson.M{"$gt": k.TotalGameTime}},
+		bson.M{"wavesSurvived": k.WavesSurvived, "averageWaveTime": k.AverageWaveTime, "totalGameTime": k.TotalGameTime, "_id": bson.M{"$gt": k.ID}},
+	}}
+}
+
+// rankedBefore matches the results ranked before the key.
+func (k rankingKey) rankedBefore() bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"wavesSurvived": bson.M{"$gt": k.WavesSurvived}},
+		bson.M{"wavesSurvived": k.WavesSurvived, "averageWaveTime": bson.M{"$gt": k.AverageWaveTime}},
+		bson.M{"wavesSurvived": k.WavesSurvived, "averageWaveTime": k.AverageWaveTime, "totalGameTime": bson.M{"$lt": k.TotalGameTime}},
+		bson.M{"wavesSurvived": k.WavesSurvived, "averageWaveTime": k.AverageWaveTime, "totalGameTime": k.TotalGameTime, "_id": bson.M{"$lt": k.ID}},
+	}}
+}
+
+// RankingCursor is the position of an entry on a leaderboard page. A page requested
+// with it continues after the entry, or ends before it when Before is set, in the sort
+// direction of the request. Cursors are opaque to clients.
+type RankingCursor struct {
+	Key    rankingKey `json:"k"`
+	Before bool       `json:"b,omitempty"`
+}
+
+// Encode returns the cursor as sent to clients, or an empty string when the key can not
+// be encoded.
+func (c RankingCursor) Encode() string {
+	encoded, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// ParseRankingCursor decodes a cursor returned with a rankings page.
+func ParseRankingCursor(cursor string) (RankingCursor, error) {
+	var parsed RankingCursor
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || json.Unmarshal(decoded, &parsed) != nil || parsed.Key.ID.IsZero() {
+		return parsed, ErrInvalidCursor
+	}
+	return parsed, nil
+}
+
+// setCursors sets the cursors of the entries before and after the page.
+func (p *PaginatedRankingResults) setCursors(hasPrev, hasNext bool) {
+	if len(p.Data) == 0 {
+		return
+	}
+	if hasPrev {
+		p.Pagination.Prev = RankingCursor{p.Data[0].rankingKey(), true}.Encode()
+	}
+	if hasNext {
+		p.Pagination.Next = RankingCursor{p.Data[len(p.Data)-1].rankingKey(), false}.Encode()
+	}
+}
+
+// cursorNeighbours reports whether there are entries before and after a page read from
+// the cursor, hasMore being whether more entries were found than the page holds.
+func cursorNeighbours(cursor RankingCursor, hasMore bool) (hasPrev, hasNext bool) {
+	if cursor.Before {
+		return hasMore, true
+	}
+	return true, hasMore
+}
+
+// GetRankingsAfterCursor returns the page of the leaderboard next to opts.Cursor.
+// Unlike GetAllRankingsPaginated it neither ranks nor skips over the whole collection:
+// the page is read from the ranking index starting at the cursor, and the rankings are
+// counted for the first entry only, or for every entry when filtering.
+func GetRankingsAfterCursor(ctx context.Context, opts GetRankingsOptions) (PaginatedRankingResults, error) {
+	timer := prometheus.NewTimer(metrics.RankingAggregationDuration.WithLabelValues("cursor"))
+	defer timer.ObserveDuration()
+
+	cursor, err := ParseRankingCursor(opts.Cursor)
+	if err != nil {
+		return PaginatedRankingResults{}, err
+	}
+	if err := opts.filtersError(); err != nil {
+		return PaginatedRankingResults{}, err
+	}
+
+	collection := GetCollection[GameResult]()
+	filter := rankingDocumentFilter(opts)
+	size := opts.GetRankingsPagination.Size
+
+	// Read away from the cursor, towards the worst results when going forward in ascending order
+	towardsWorst := (opts.GetSortDirection() > 0) != cursor.Before
+	keyset, sort := cursor.Key.rankedAfter(), LeaderboardRankingAggregationSort
+	if !towardsWorst {
+		keyset, sort = cursor.Key.rankedBefore(), reverseSort(LeaderboardRankingAggregationSort)
+	}
+
+	results, err := collection.Find(ctx,
+		bson.M{"$and": bson.A{filter, keyset}},
+		options.Find().SetSort(sort).SetLimit(int64(size+1)),
+	)
+	if err != nil {
+		return PaginatedRankingResults{}, err
+	}
+
+	hasMore := len(results) > size
+	if hasMore {
+		results = results[:size]
+	}
+	if cursor.Before {
+		reverseResults(results)
+	}
+
+	page := PaginatedRankingResults{Data: make([]RankingResultsItem, len(results))}
+	for i := range results {
+		page.Data[i] = newRankingResultsItem(&results[i], 0)
+	}
+	if err := countRankings(ctx, page.Data, opts); err != nil {
+		return PaginatedRankingResults{}, err
+	}
+
+	total, err := collection.Count(ctx, filter)
+	if err != nil {
+		return PaginatedRankingResults{}, err
+	}
+	page.Pagination.Total = int(total)
+	page.Pagination.Max = int(math.Ceil(float64(total) / float64(size)))
+
+	page.setCursors(cursorNeighbours(cursor, hasMore))
+	return page, nil
+}
+
+// countRankings sets the rankings of the entries of a page, counting the results ranked
+// before them. Without filters the entries are consecutive, so only the best is counted.
+func countRankings(ctx context.Context, items []RankingResultsItem, options GetRankingsOptions) error {
+	if len(items) == 0 {
+		return nil
+	}
+	collection := GetCollection[GameResult]()
+	count := func(key rankingKey) (int, error) {
+		ranking, err := collection.Count(ctx, bson.M{"$and": bson.A{visibleResults(), key.rankedBefore()}})
+		return int(ranking), err
+	}
+
+	if !hasRankingFilters(options) {
+		best := 0
+		if options.GetSortDirection() < 0 {
+			best = len(items) - 1
+		}
+		ranking, err := count(items[best].rankingKey())
+		if err != nil {
+			return err
+		}
+		for i := range items {
+			items[i].Ranking = ranking + abs(i-best)
+		}
+		return nil
+	}
+
+	for i := range items {
+		ranking, err := count(items[i].rankingKey())
+		if err != nil {
+			return err
+		}
+		items[i].Ranking = ranking
+	}
+	return nil
+}
+
+// rankingDocumentFilter matches the results shown on the leaderboard with the filters of
+// the options, like BuildFilters does on the ranked results.
+func rankingDocumentFilter(options GetRankingsOptions) bson.M {
+	filter := visibleResults()
+	if steamName, ok := options.Filters["steamName"].(string); ok && steamName != "" {
+		filter["player.steamName"] = bson.M{"$regex": steamName, "$options": "i"}
+	}
+	if steamId, ok := options.Filters["steamId"].(string); ok && steamId != "" {
+		filter["player.steamId"] = steamId
+	}
+	if gameId, ok := options.Filters["gameId"].(string); ok && gameId != "" {
+		filter["_id"], _ = parseGameIdFilter(gameId)
+	}
+	return filter
+}
+
+func visibleResults() bson.M {
+	return bson.M{"hidden": bson.M{"$ne": true}}
+}
+
+func hasRankingFilters(options GetRankingsOptions) bool {
+	for _, filter := range rankingFilters {
+		if value, ok := options.Filters[filter].(string); ok && value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func newRankingResultsItem(result *GameResult, ranking int) RankingResultsItem {
+	return RankingResultsItem{
+		ExtraGameStatsData: result.Extra,
+		GameId:             result.ID,
+		Player:             result.Player,
+		Ranking:            ranking,
+		AverageWaveTime:    result.AverageWaveTime,
+		TotalGameTime:      result.TotalGameTime,
+		WavesSurvived:      result.WavesSurvived,
+	}
+}
+
+func reverseSort(sort bson.D) bson.D {
+	reversed := make(bson.D, len(sort))
+	for i, field := range sort {
+		direction, ok := field.Value.(int)
+		if !ok {
+			panic(fmt.Sprintf("reverseSort: unexpected direction %v of %s", field.Value, field.Key))
+		}
+		reversed[i] = bson.E{Key: field.Key, Value: -direction}
+	}
+	return reversed
+}
+
+func reverseResults[T any](results []T) {
+	for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+		results[i], results[j] = results[j], results[i]
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
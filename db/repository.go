@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"bob-leaderboard/app/logger"
+)
+
+// ErrDuplicateSubmission is returned when inserting a result with the idempotency key of
+// a result the player already stored.
+var ErrDuplicateSubmission = errors.New("duplicate submission")
+
+// LeaderboardRepository is the storage used by the leaderboard api.
+type LeaderboardRepository interface {
+	// InsertResult stores a new game result and sets its ID, or returns
+	// ErrDuplicateSubmission when the player stored a result with its idempotency key.
+	InsertResult(ctx context.Context, result *GameResult) (primitive.ObjectID, error)
+	// InsertResults stores several new game results at once, in order, and sets their IDs.
+	InsertResults(ctx context.Context, results []*GameResult) error
+	// GetRankingsPage returns a page of the ranked leaderboard, by page number or next to
+	// the cursor of the options, with the cursors of the pages around it.
+	GetRankingsPage(ctx context.Context, options GetRankingsOptions) (PaginatedRankingResults, error)
+	// GetRankingForGame returns the rank of a result on the whole leaderboard, or ErrGameNotFound.
+	GetRankingForGame(ctx context.Context, gameId primitive.ObjectID) (int, error)
+	// FindResultByID returns a single game result, or ErrGameNotFound.
+	FindResultByID(ctx context.Context, id primitive.ObjectID) (*GameResult, error)
+	// FindResultBySubmission returns the result the steam user stored with the idempotency
+	// key, or ErrGameNotFound.
+	FindResultBySubmission(ctx context.Context, steamId, key string) (*GameResult, error)
+	// FindResultsByPlayer returns every result submitted by the steam user, oldest first.
+	FindResultsByPlayer(ctx context.Context, steamId string) ([]GameResult, error)
+	// BanPlayer bans the steam user and hides their results, returning how many were hidden.
+	BanPlayer(ctx context.Context, steamId, reason string) (int64, error)
+	// UnbanPlayer lifts the ban of the steam user, returning how many results are shown again.
+	UnbanPlayer(ctx context.Context, steamId string) (int64, error)
+	// IsBanned reports whether the steam user is banned.
+	IsBanned(ctx context.Context, steamId string) (bool, error)
+	// Version changes with every insert and moderation action, the responses built from
+	// the leaderboard are validated with it.
+	Version(ctx context.Context) (int64, error)
+}
+
+// MongoRepository is the LeaderboardRepository backed by the results collection.
+type MongoRepository struct{}
+
+func NewMongoRepository() *MongoRepository {
+	return &MongoRepository{}
+}
+
+func (r *MongoRepository) InsertResult(ctx context.Context, result *GameResult) (primitive.ObjectID, error) {
+	if _, err := GetCollection[GameResult]().InsertOne(ctx, result); err != nil {
+		if result.IdempotencyKey != "" && mongo.IsDuplicateKeyError(err) {
+			return primitive.NilObjectID, ErrDuplicateSubmission
+		}
+		return primitive.NilObjectID, err
+	}
+	bumpLeaderboardVersion(ctx)
+	return result.ID, nil
+}
+
+func (r *MongoRepository) InsertResults(ctx context.Context, results []*GameResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+	if _, err := GetCollection[GameResult]().InsertMany(ctx, results); err != nil {
+		return err
+	}
+	bumpLeaderboardVersion(ctx)
+	return nil
+}
+
+func (r *MongoRepository) GetRankingsPage(ctx context.Context, options GetRankingsOptions) (PaginatedRankingResults, error) {
+	options = options.Validate()
+	if options.Cursor != "" {
+		return GetRankingsAfterCursor(ctx, options)
+	}
+
+	page, err := GetAllRankingsPaginated(ctx, options)
+	if err != nil {
+		return page, err
+	}
+	page.setCursors(options.Page > 1, options.Page < page.Pagination.Max)
+	return page, nil
+}
+
+func (r *MongoRepository) GetRankingForGame(ctx context.Context, gameId primitive.ObjectID) (int, error) {
+	return GetRankingForGame(ctx, gameId)
+}
+
+func (r *MongoRepository) FindResultByID(ctx context.Context, id primitive.ObjectID) (*GameResult, error) {
+	result, err := GetCollection[GameResult]().FindByID(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrGameNotFound
+	}
+	return result, err
+}
+
+func (r *MongoRepository) FindResultBySubmission(ctx context.Context, steamId, key string) (*GameResult, error) {
+	results, err := GetCollection[GameResult]().Find(ctx,
+		bson.M{"player.steamId": steamId, "idempotencyKey": key},
+		options.Find().SetLimit(1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, ErrGameNotFound
+	}
+	return &results[0], nil
+}
+
+func (r *MongoRepository) FindResultsByPlayer(ctx context.Context, steamId string) ([]GameResult, error) {
+	return GetCollection[GameResult]().Find(ctx,
+		bson.M{"player.steamId": steamId},
+		options.Find().SetSort(bson.D{{"_id", 1}}),
+	)
+}
+
+func (r *MongoRepository) BanPlayer(ctx context.Context, steamId, reason string) (int64, error) {
+	_, err := GetCollection[Ban]().UpsertOne(ctx,
+		bson.M{"steamId": steamId},
+		bson.M{
+			"$set":         bson.M{"reason": reason},
+			"$setOnInsert": bson.M{"createdAt": time.Now().UTC()},
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := GetCollection[GameResult]().UpdateMany(ctx,
+		bson.M{"player.steamId": steamId, "hidden": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"hidden": true}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	bumpLeaderboardVersion(ctx)
+	return result.ModifiedCount, nil
+}
+
+func (r *MongoRepository) UnbanPlayer(ctx context.Context, steamId string) (int64, error) {
+	if _, err := GetCollection[Ban]().DeleteMany(ctx, bson.M{"steamId": steamId}); err != nil {
+		return 0, err
+	}
+
+	result, err := GetCollection[GameResult]().UpdateMany(ctx,
+		bson.M{"player.steamId": steamId, "hidden": true},
+		bson.M{"$unset": bson.M{"hidden": ""}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	bumpLeaderboardVersion(ctx)
+	return result.ModifiedCount, nil
+}
+
+func (r *MongoRepository) IsBanned(ctx context.Context, steamId string) (bool, error) {
+	count, err := GetCollection[Ban]().Count(ctx, bson.M{"steamId": steamId})
+	return count > 0, err
+}
+
+func (r *MongoRepository) Version(ctx context.Context) (int64, error) {
+	return GetVersion(ctx, LeaderboardVersion)
+}
+
+// bumpLeaderboardVersion records a change of the leaderboard once it is stored. A failure
+// is only logged, the change itself went through and the cached responses catch up with
+// the next one.
+func bumpLeaderboardVersion(ctx context.Context) {
+	if err := BumpVersion(ctx, LeaderboardVersion); err != nil {
+		logger.Warning("Error bumping the leaderboard version: %v", err)
+	}
+}
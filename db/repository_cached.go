@@ -0,0 +1,193 @@
+package db
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"bob-leaderboard/app/metrics"
+)
+
+// RankingSource loads every stored result, to rank them from scratch.
+type RankingSource func(ctx context.Context) ([]GameResult, error)
+
+// LoadRankedResults is the RankingSource of the results collection, it reads the
+// visible results without their wave times.
+func LoadRankedResults(ctx context.Context) ([]GameResult, error) {
+	return GetCollection[GameResult]().Find(ctx,
+		visibleResults(),
+		options.Find().SetSort(LeaderboardRankingAggregationSort).SetProjection(bson.M{"waveTimes": 0}),
+	)
+}
+
+// CachedRepository serves the leaderboard pages and the rankings of single results from
+// a RankingIndex, kept up to date with the results inserted, hidden and shown again
+// through it. Pages filtered by player are read from the wrapped repository.
+//
+// Changes made elsewhere, by another instance or a command, are only picked up by
+// Rebuild, or by Add for the results inserted by other instances.
+type CachedRepository struct {
+	LeaderboardRepository
+	index  *RankingIndex
+	source RankingSource
+
+	// mu orders the index changes with the rebuilds, journal holds the changes made
+	// while the results of a rebuild are loaded so they are applied again once it is done
+	mu         sync.Mutex
+	rebuilding bool
+	journal    []func()
+	rebuildMu  sync.Mutex
+}
+
+// NewCachedRepository wraps the repository, the index is empty until Rebuild.
+func NewCachedRepository(repository LeaderboardRepository, source RankingSource) *CachedRepository {
+	return &CachedRepository{LeaderboardRepository: repository, index: NewRankingIndex(), source: source}
+}
+
+// Index returns the ranking served by the repository.
+func (r *CachedRepository) Index() *RankingIndex {
+	return r.index
+}
+
+// Rebuild ranks every result of the source from scratch and returns how many are ranked.
+// The current ranking keeps being served while the results are loaded.
+func (r *CachedRepository) Rebuild(ctx context.Context) (int, error) {
+	r.rebuildMu.Lock()
+	defer r.rebuildMu.Unlock()
+
+	r.mu.Lock()
+	r.rebuilding, r.journal = true, nil
+	r.mu.Unlock()
+
+	results, err := r.source(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		r.index.Replace(results)
+		for _, change := range r.journal {
+			change()
+		}
+	}
+	r.rebuilding, r.journal = false, nil
+	metrics.RankingIndexSize.Set(float64(r.index.Len()))
+
+	return r.index.Len(), err
+}
+
+// Add ranks a result stored without going through the repository, e.g. by another instance.
+func (r *CachedRepository) Add(result GameResult) {
+	r.change(func() { r.index.Add(&result) })
+}
+
+func (r *CachedRepository) change(change func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	change()
+	if r.rebuilding {
+		r.journal = append(r.journal, change)
+	}
+	metrics.RankingIndexSize.Set(float64(r.index.Len()))
+}
+
+func (r *CachedRepository) InsertResult(ctx context.Context, result *GameResult) (primitive.ObjectID, error) {
+	id, err := r.LeaderboardRepository.InsertResult(ctx, result)
+	if err == nil {
+		r.Add(*result)
+	}
+	return id, err
+}
+
+func (r *CachedRepository) InsertResults(ctx context.Context, results []*GameResult) error {
+	if err := r.LeaderboardRepository.InsertResults(ctx, results); err != nil {
+		return err
+	}
+	stored := make([]GameResult, len(results))
+	for i, result := range results {
+		stored[i] = *result
+	}
+	r.change(func() {
+		for i := range stored {
+			r.index.Add(&stored[i])
+		}
+	})
+	return nil
+}
+
+func (r *CachedRepository) GetRankingsPage(ctx context.Context, options GetRankingsOptions) (PaginatedRankingResults, error) {
+	options = options.Validate()
+	if err := options.filtersError(); err != nil {
+		return PaginatedRankingResults{}, err
+	}
+
+	steamName, _ := options.Filters["steamName"].(string)
+	steamId, _ := options.Filters["steamId"].(string)
+	gameId, _ := options.Filters["gameId"].(string)
+	if steamName != "" || steamId != "" || (gameId != "" && options.Cursor != "") {
+		return r.LeaderboardRepository.GetRankingsPage(ctx, options)
+	}
+
+	timer := prometheus.NewTimer(metrics.RankingAggregationDuration.WithLabelValues("materialised"))
+	defer timer.ObserveDuration()
+
+	if gameId == "" {
+		return r.index.Page(options)
+	}
+
+	page := PaginatedRankingResults{Data: []RankingResultsItem{}}
+	id, _ := parseGameIdFilter(gameId)
+	if entry, ok := r.index.Entry(id); ok {
+		page.Pagination.Total, page.Pagination.Max = 1, 1
+		if options.GetRankingsPagination.Page == 1 {
+			page.Data = append(page.Data, entry)
+		}
+	}
+	return page, nil
+}
+
+func (r *CachedRepository) GetRankingForGame(ctx context.Context, gameId primitive.ObjectID) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	ranking, ok := r.index.Ranking(gameId)
+	if !ok {
+		return 0, ErrGameNotFound
+	}
+	return ranking, nil
+}
+
+func (r *CachedRepository) BanPlayer(ctx context.Context, steamId, reason string) (int64, error) {
+	hidden, err := r.LeaderboardRepository.BanPlayer(ctx, steamId, reason)
+	if err != nil {
+		return hidden, err
+	}
+	return hidden, r.rankPlayer(ctx, steamId)
+}
+
+func (r *CachedRepository) UnbanPlayer(ctx context.Context, steamId string) (int64, error) {
+	shown, err := r.LeaderboardRepository.UnbanPlayer(ctx, steamId)
+	if err != nil {
+		return shown, err
+	}
+	return shown, r.rankPlayer(ctx, steamId)
+}
+
+// rankPlayer ranks the results of the player again, after they were hidden or shown.
+func (r *CachedRepository) rankPlayer(ctx context.Context, steamId string) error {
+	results, err := r.LeaderboardRepository.FindResultsByPlayer(ctx, steamId)
+	if err != nil {
+		return err
+	}
+	r.change(func() {
+		for i := range results {
+			r.index.Add(&results[i])
+		}
+	})
+	return nil
+}
@@ -0,0 +1,345 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"regexp"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryRepository is an in-memory LeaderboardRepository, ranking results the same
+// way as the Mongo ranking pipeline. It is meant for tests and local development.
+type MemoryRepository struct {
+	mu      sync.RWMutex
+	results []GameResult
+	bans    map[string]string
+	version int64
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{bans: map[string]string{}}
+}
+
+func (r *MemoryRepository) InsertResult(ctx context.Context, result *GameResult) (primitive.ObjectID, error) {
+	if err := ctx.Err(); err != nil {
+		return primitive.NilObjectID, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.findSubmission(result.Player.SteamId, result.IdempotencyKey) != nil {
+		return primitive.NilObjectID, ErrDuplicateSubmission
+	}
+	id := result.ID
+	if id.IsZero() {
+		id = primitive.NewObjectID()
+	}
+	result.OnInsert(id)
+	r.results = append(r.results, *result)
+	r.version++
+
+	return id, nil
+}
+
+func (r *MemoryRepository) InsertResults(ctx context.Context, results []*GameResult) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, result := range results {
+		if r.findSubmission(result.Player.SteamId, result.IdempotencyKey) != nil {
+			return ErrDuplicateSubmission
+		}
+		if result.ID.IsZero() {
+			result.OnInsert(primitive.NewObjectID())
+		}
+		r.results = append(r.results, *result)
+		r.version++
+	}
+	return nil
+}
+
+func (r *MemoryRepository) GetRankingsPage(ctx context.Context, options GetRankingsOptions) (PaginatedRankingResults, error) {
+	if err := ctx.Err(); err != nil {
+		return PaginatedRankingResults{}, err
+	}
+
+	options = options.Validate()
+
+	ranked, err := r.rankedResults(options)
+	if err != nil {
+		return PaginatedRankingResults{}, err
+	}
+
+	page := PaginatedRankingResults{Data: []RankingResultsItem{}}
+	if len(ranked) == 0 {
+		return page, nil
+	}
+
+	size := options.GetRankingsPagination.Size
+	page.Pagination.Total = len(ranked)
+	page.Pagination.Max = int(math.Ceil(float64(len(ranked)) / float64(size)))
+
+	if options.Cursor != "" {
+		cursor, err := ParseRankingCursor(options.Cursor)
+		if err != nil {
+			return PaginatedRankingResults{}, err
+		}
+
+		// ranked is in the order of the page, find where the cursor falls in it
+		direction := options.GetSortDirection()
+		at := sort.Search(len(ranked), func(i int) bool {
+			return ranked[i].rankingKey().compare(cursor.Key)*direction >= 0
+		})
+		start, end := at, at+size
+		if start < len(ranked) && ranked[start].rankingKey() == cursor.Key {
+			start, end = start+1, end+1
+		}
+		if cursor.Before {
+			start, end = max(at-size, 0), at
+		}
+		end = min(end, len(ranked))
+
+		hasMore := end < len(ranked)
+		if cursor.Before {
+			hasMore = start > 0
+		}
+		page.Data = ranked[start:end]
+		page.setCursors(cursorNeighbours(cursor, hasMore))
+		return page, nil
+	}
+
+	start := (options.GetRankingsPagination.Page - 1) * size
+	if start < len(ranked) {
+		end := min(start+size, len(ranked))
+		page.Data = ranked[start:end]
+	}
+	page.setCursors(options.Page > 1, options.Page < page.Pagination.Max)
+
+	return page, nil
+}
+
+func (r *MemoryRepository) GetRankingForGame(ctx context.Context, gameId primitive.ObjectID) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	ranked, err := r.rankedResults(GetRankingsOptions{
+		Filters: map[string]any{"gameId": gameId.Hex()},
+	}.Validate())
+	if err != nil {
+		return 0, err
+	}
+	if len(ranked) == 0 {
+		return 0, ErrGameNotFound
+	}
+	return ranked[0].Ranking, nil
+}
+
+func (r *MemoryRepository) FindResultByID(ctx context.Context, id primitive.ObjectID) (*GameResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, result := range r.results {
+		if result.ID == id {
+			return &result, nil
+		}
+	}
+	return nil, ErrGameNotFound
+}
+
+func (r *MemoryRepository) FindResultBySubmission(ctx context.Context, steamId, key string) (*GameResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if result := r.findSubmission(steamId, key); result != nil {
+		found := *result
+		return &found, nil
+	}
+	return nil, ErrGameNotFound
+}
+
+// findSubmission returns the result stored by the player with the idempotency key, the
+// caller holds the lock.
+func (r *MemoryRepository) findSubmission(steamId, key string) *GameResult {
+	if key == "" {
+		return nil
+	}
+	for i := range r.results {
+		if r.results[i].Player.SteamId == steamId && r.results[i].IdempotencyKey == key {
+			return &r.results[i]
+		}
+	}
+	return nil
+}
+
+func (r *MemoryRepository) FindResultsByPlayer(ctx context.Context, steamId string) ([]GameResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	results := []GameResult{}
+	for _, result := range r.results {
+		if result.Player.SteamId == steamId {
+			results = append(results, result)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return bytes.Compare(results[i].ID[:], results[j].ID[:]) < 0
+	})
+
+	return results, nil
+}
+
+// Results returns every stored result, it is the RankingSource of the repository.
+func (r *MemoryRepository) Results(ctx context.Context) ([]GameResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]GameResult(nil), r.results...), nil
+}
+
+func (r *MemoryRepository) BanPlayer(ctx context.Context, steamId, reason string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.bans[steamId] = reason
+	r.version++
+	return r.setHidden(steamId, true), nil
+}
+
+func (r *MemoryRepository) UnbanPlayer(ctx context.Context, steamId string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.bans, steamId)
+	r.version++
+	return r.setHidden(steamId, false), nil
+}
+
+func (r *MemoryRepository) IsBanned(ctx context.Context, steamId string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, banned := r.bans[steamId]
+	return banned, nil
+}
+
+func (r *MemoryRepository) Version(ctx context.Context) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.version, nil
+}
+
+// setHidden changes the hidden flag of every result of the player and returns how many changed.
+func (r *MemoryRepository) setHidden(steamId string, hidden bool) int64 {
+	var changed int64
+	for i := range r.results {
+		if r.results[i].Player.SteamId == steamId && r.results[i].Hidden != hidden {
+			r.results[i].Hidden = hidden
+			changed++
+		}
+	}
+	return changed
+}
+
+// rankedResults mirrors GetRankingPipeline: rank every result, then filter, then
+// order by rank in the requested direction.
+func (r *MemoryRepository) rankedResults(options GetRankingsOptions) ([]RankingResultsItem, error) {
+	if err := options.filtersError(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	results := make([]GameResult, 0, len(r.results))
+	for _, result := range r.results {
+		if !result.Hidden {
+			results = append(results, result)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return compareRanking(&results[i], &results[j]) < 0
+	})
+
+	var steamNamePattern *regexp.Regexp
+	if steamName, ok := options.Filters["steamName"].(string); ok && steamName != "" {
+		pattern, err := regexp.Compile("(?i)" + steamName)
+		if err != nil {
+			return nil, err
+		}
+		steamNamePattern = pattern
+	}
+	steamId, _ := options.Filters["steamId"].(string)
+	gameIdFilter, _ := options.Filters["gameId"].(string)
+	gameId, _ := parseGameIdFilter(gameIdFilter)
+
+	ranked := []RankingResultsItem{}
+	for ranking, result := range results {
+		if steamNamePattern != nil && !steamNamePattern.MatchString(result.Player.Name) {
+			continue
+		}
+		if steamId != "" && result.Player.SteamId != steamId {
+			continue
+		}
+		if !gameId.IsZero() && result.ID != gameId {
+			continue
+		}
+
+		ranked = append(ranked, newRankingResultsItem(&result, ranking))
+	}
+
+	if options.GetSortDirection() < 0 {
+		for i, j := 0, len(ranked)-1; i < j; i, j = i+1, j-1 {
+			ranked[i], ranked[j] = ranked[j], ranked[i]
+		}
+	}
+
+	return ranked, nil
+}
+
+// compareRanking orders two results the same way as LeaderboardRankingAggregationSort.
+func compareRanking(a, b *GameResult) int {
+	return a.rankingKey().compare(b.rankingKey())
+}
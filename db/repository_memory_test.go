@@ -0,0 +1,21 @@
+package db_test
+
+import (
+	"testing"
+
+	"bob-leaderboard/db"
+	"bob-leaderboard/db/dbtest"
+)
+
+func TestMemoryRepositoryConformance(t *testing.T) {
+	dbtest.RunRepositoryConformance(t, func(t *testing.T) db.LeaderboardRepository {
+		return db.NewMemoryRepository()
+	})
+}
+
+func TestCachedRepositoryConformance(t *testing.T) {
+	dbtest.RunRepositoryConformance(t, func(t *testing.T) db.LeaderboardRepository {
+		memory := db.NewMemoryRepository()
+		return db.NewCachedRepository(memory, memory.Results)
+	})
+}
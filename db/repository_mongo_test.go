@@ -0,0 +1,64 @@
+package db_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"bob-leaderboard/db"
+	"bob-leaderboard/db/dbtest"
+)
+
+// testMongoURIEnv names the environment variable holding the URI of the Mongo server the
+// Mongo backed tests and benchmarks run against, they are skipped when it is not set.
+const testMongoURIEnv = "BOB_TEST_MONGO_URI"
+
+// connectTestDatabase connects to a scratch database on the test Mongo server, it is
+// dropped when the test ends.
+func connectTestDatabase(tb testing.TB, name string) {
+	tb.Helper()
+	uri := os.Getenv(testMongoURIEnv)
+	if uri == "" {
+		tb.Skipf("%s is not set", testMongoURIEnv)
+	}
+
+	if err := db.CreateConnection(context.Background(), uri, "bob_test_"+name); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		ctx := context.Background()
+		if err := db.DropDatabase(ctx); err != nil {
+			tb.Error(err)
+		}
+		db.Disconnect(ctx)
+	})
+	resetTestDatabase(tb)
+}
+
+// resetTestDatabase empties the scratch database and creates the indexes again.
+func resetTestDatabase(tb testing.TB) {
+	tb.Helper()
+	ctx := context.Background()
+	if err := db.DropDatabase(ctx); err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := db.SyncIndexes(ctx, db.IndexSyncOptions{}); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestMongoRepositoryConformance(t *testing.T) {
+	connectTestDatabase(t, "conformance")
+	dbtest.RunRepositoryConformance(t, func(t *testing.T) db.LeaderboardRepository {
+		resetTestDatabase(t)
+		return db.NewMongoRepository()
+	})
+}
+
+func TestMongoCachedRepositoryConformance(t *testing.T) {
+	connectTestDatabase(t, "cached_conformance")
+	dbtest.RunRepositoryConformance(t, func(t *testing.T) db.LeaderboardRepository {
+		resetTestDatabase(t)
+		return db.NewCachedRepository(db.NewMongoRepository(), db.LoadRankedResults)
+	})
+}
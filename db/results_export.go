@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// exportBatchSize is the number of results read from the cursor per round trip.
+const exportBatchSize = 1000
+
+// ExportFilter selects the results of an export.
+type ExportFilter struct {
+	// From and To bound the creation time of the results, From included and To excluded,
+	// a zero time leaves that end open
+	From, To time.Time
+	SteamId  string
+	// VisibleOnly leaves out the hidden results of banned players
+	VisibleOnly bool
+}
+
+func (f ExportFilter) query() bson.M {
+	query := bson.M{}
+	createdAt := bson.M{}
+	if !f.From.IsZero() {
+		createdAt["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		createdAt["$lt"] = f.To
+	}
+	if len(createdAt) > 0 {
+		query["createdAt"] = createdAt
+	}
+	if f.SteamId != "" {
+		query["player.steamId"] = f.SteamId
+	}
+	if f.VisibleOnly {
+		query["hidden"] = bson.M{"$ne": true}
+	}
+	return query
+}
+
+// ExportResults calls write with every result matching the filter, in the order they were
+// stored. The results are streamed from a cursor, so only a batch of them is held in memory,
+// and the export is only bounded by ctx. It returns how many results were written.
+func ExportResults(ctx context.Context, filter ExportFilter, write func(*GameResult) error) (int, error) {
+	return GetCollection[GameResult]().Each(ctx, filter.query(), write,
+		options.Find().SetSort(bson.D{{"_id", 1}}).SetBatchSize(exportBatchSize),
+	)
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// recomputeBatchSize is the number of updates sent per bulk write.
+const recomputeBatchSize = 500
+
+// RecomputeResult reports the results checked by RecomputeRankingKeys.
+type RecomputeResult struct {
+	Checked int64
+	Changed int64
+	DryRun  bool
+}
+
+// RecomputeRankingKeys recomputes the ranking keys (waves survived, total and average wave
+// time) of every stored result from its wave times, and fixes the results where they differ.
+// With dryRun set nothing is changed and Changed reports how many results would be.
+//
+// The results are streamed from a cursor in _id order, which the updates don't change,
+// and the fixes are written a batch at a time as they are found, so only a batch of
+// results is held in memory.
+func RecomputeRankingKeys(ctx context.Context, dryRun bool) (RecomputeResult, error) {
+	report := RecomputeResult{DryRun: dryRun}
+	collection := GetCollection[GameResult]()
+
+	var updates []mongo.WriteModel
+	flush := func() error {
+		if len(updates) == 0 || dryRun {
+			updates = nil
+			return nil
+		}
+		_, err := collection.BulkWrite(ctx, updates)
+		updates = nil
+		return err
+	}
+
+	_, err := collection.Each(ctx, bson.M{}, func(result *GameResult) error {
+		report.Checked++
+
+		recomputed := *result
+		recomputed.SetWaveTimes(result.WaveTimes)
+		if recomputed.WavesSurvived == result.WavesSurvived &&
+			recomputed.TotalGameTime == result.TotalGameTime &&
+			recomputed.AverageWaveTime == result.AverageWaveTime &&
+			len(recomputed.WaveTimes) == len(result.WaveTimes) {
+			return nil
+		}
+
+		report.Changed++
+		updates = append(updates, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": result.ID}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"waveTimes":       recomputed.WaveTimes,
+				"wavesSurvived":   recomputed.WavesSurvived,
+				"totalGameTime":   recomputed.TotalGameTime,
+				"averageWaveTime": recomputed.AverageWaveTime,
+			}}))
+
+		if len(updates) >= recomputeBatchSize {
+			return flush()
+		}
+		return nil
+	}, options.Find().SetSort(bson.D{{"_id", 1}}).SetBatchSize(exportBatchSize))
+	if err != nil {
+		return report, err
+	}
+
+	if err := flush(); err != nil {
+		return report, err
+	}
+	if !dryRun && report.Changed > 0 {
+		bumpLeaderboardVersion(ctx)
+	}
+	return report, nil
+}
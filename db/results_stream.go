@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ResultStream follows the results inserted by every server instance through a change
+// stream, which needs MongoDB to run as a replica set or a sharded cluster.
+type ResultStream struct {
+	// resumeToken is where the next Watch resumes, so no insert is missed between two
+	resumeToken bson.Raw
+}
+
+// Watch calls onInsert with every inserted result until ctx is done or the stream fails.
+// Calling it again after a failure resumes after the last result seen.
+func (s *ResultStream) Watch(ctx context.Context, onInsert func(result *GameResult)) error {
+	opts := options.ChangeStream()
+	if s.resumeToken != nil {
+		opts.SetResumeAfter(s.resumeToken)
+	}
+
+	stream, err := GetCollection[GameResult]().Watch(ctx,
+		mongo.Pipeline{bson.D{{"$match", bson.D{{"operationType", "insert"}}}}},
+		opts,
+	)
+	if err != nil {
+		return err
+	}
+	defer stream.Close(context.Background())
+
+	for stream.Next(ctx) {
+		var change struct {
+			FullDocument GameResult `bson:"fullDocument"`
+		}
+		if err := stream.Decode(&change); err != nil {
+			return err
+		}
+		s.resumeToken = stream.ResumeToken()
+		onInsert(&change.FullDocument)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return stream.Err()
+}
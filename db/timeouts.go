@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"bob-leaderboard/app"
+	"bob-leaderboard/app/logger"
+)
+
+// OperationType groups database operations which share a default timeout.
+type OperationType string
+
+const (
+	OperationRead      OperationType = "Read"
+	OperationWrite     OperationType = "Write"
+	OperationAggregate OperationType = "Aggregate"
+	OperationIndex     OperationType = "Index"
+	OperationConnect   OperationType = "Connect"
+)
+
+// cursorCloseTimeout bounds how long we wait for the server to kill a cursor.
+const cursorCloseTimeout = 5 * time.Second
+
+// OperationTimeout returns the timeout for the operation type, configured with
+// Mongo.Timeouts.<type> as a duration string (e.g. "5s"). An unset timeout falls back to
+// the one of app.DefaultConfig.
+func OperationTimeout(op OperationType) time.Duration {
+	if timeout := configuredTimeout(app.Settings, op); timeout > 0 {
+		return timeout
+	}
+	return configuredTimeout(app.DefaultConfig(), op)
+}
+
+func configuredTimeout(config app.AppConfig, op OperationType) time.Duration {
+	timeouts := config.Mongo.Timeouts
+	switch op {
+	case OperationRead:
+		return timeouts.Read.Duration
+	case OperationWrite:
+		return timeouts.Write.Duration
+	case OperationAggregate:
+		return timeouts.Aggregate.Duration
+	case OperationIndex:
+		return timeouts.Index.Duration
+	case OperationConnect:
+		return timeouts.Connect.Duration
+	}
+	return 0
+}
+
+// withTimeout derives a context bounded by the timeout of the operation type,
+// an earlier deadline on ctx is kept as is.
+func withTimeout(ctx context.Context, op OperationType) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, OperationTimeout(op))
+}
+
+type closableCursor interface {
+	Close(ctx context.Context) error
+}
+
+// closeCursor closes a cursor even if ctx has already been cancelled, so the
+// server side cursor is killed when the request that opened it goes away.
+func closeCursor(ctx context.Context, cursor closableCursor) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cursorCloseTimeout)
+	defer cancel()
+
+	if err := cursor.Close(ctx); err != nil {
+		logger.Warning("Error closing cursor: %v", err)
+	}
+}
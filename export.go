@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	routing "github.com/go-ozzo/ozzo-routing"
+
+	"bob-leaderboard/app"
+	"bob-leaderboard/db"
+)
+
+// exportContentTypes are the export formats and their content type, both are read back
+// by the import.
+var exportContentTypes = map[string]string{
+	"ndjson": "application/x-ndjson",
+	"csv":    "text/csv; charset=utf-8",
+}
+
+// exportParams are the export options, given as query parameters or command flags of the
+// same names.
+type exportParams struct {
+	Format  string
+	From    string
+	To      string
+	Season  string
+	SteamId string
+	Visible bool
+}
+
+// filter returns the export filter and format, reporting every invalid option.
+func (p exportParams) filter() (db.ExportFilter, string, []FieldError) {
+	filter := db.ExportFilter{SteamId: p.SteamId, VisibleOnly: p.Visible}
+	var details []FieldError
+
+	format := p.Format
+	if format == "" {
+		format = "ndjson"
+	}
+	if _, ok := exportContentTypes[format]; !ok {
+		details = append(details, FieldError{"format", FieldInvalidValue, "must be ndjson or csv"})
+	}
+
+	for _, bound := range []struct {
+		name  string
+		value string
+		time  *time.Time
+	}{{"from", p.From, &filter.From}, {"to", p.To, &filter.To}} {
+		if bound.value == "" {
+			continue
+		}
+		parsed, err := parseTimeParam(bound.value)
+		if err != nil {
+			details = append(details, FieldError{bound.name, FieldInvalidValue, "must be a date (2006-01-02) or an RFC 3339 time"})
+			continue
+		}
+		*bound.time = parsed
+	}
+
+	if p.Season != "" {
+		season, ok := app.Settings.Season(p.Season)
+		switch {
+		case !ok:
+			details = append(details, FieldError{"season", FieldInvalidValue, fmt.Sprintf("unknown season %q", p.Season)})
+		case p.From != "" || p.To != "":
+			details = append(details, FieldError{"season", FieldInvalidValue, "cannot be combined with from and to"})
+		default:
+			filter.From, filter.To = season.Start, season.End
+		}
+	}
+
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.To.After(filter.From) {
+		details = append(details, FieldError{"to", FieldInvalidValue, "must be after from"})
+	}
+	return filter, format, details
+}
+
+// parseTimeParam reads a date, as midnight UTC, or an RFC 3339 time.
+func parseTimeParam(value string) (time.Time, error) {
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// resultWriter writes exported results in one of the export formats, buffering them until
+// Flush.
+type resultWriter interface {
+	Write(result *db.GameResult) error
+	Flush() error
+}
+
+func newResultWriter(format string, w io.Writer) resultWriter {
+	if format == "csv" {
+		return &csvResultWriter{writer: csv.NewWriter(w)}
+	}
+	buffered := bufio.NewWriter(w)
+	return &ndjsonResultWriter{buffered, json.NewEncoder(buffered)}
+}
+
+// ndjsonResultWriter writes every result as a JSON document on its own line.
+type ndjsonResultWriter struct {
+	buffered *bufio.Writer
+	encoder  *json.Encoder
+}
+
+func (w *ndjsonResultWriter) Write(result *db.GameResult) error {
+	return w.encoder.Encode(result)
+}
+
+func (w *ndjsonResultWriter) Flush() error {
+	return w.buffered.Flush()
+}
+
+// csvResultColumns is the header of the CSV export, the wave times are in a single
+// column separated by semicolons.
+var csvResultColumns = []string{
+	"id", "createdAt", "runId", "steamId", "steamName", "hidden",
+	"wavesSurvived", "totalGameTime", "averageWaveTime",
+	"damageDealt", "enemiesKilled", "essenceHarvested", "essenceSpent", "towersBuilt", "upgradesPurchased",
+	"waveTimes",
+}
+
+// csvResultWriter writes a row per result after the csvResultColumns header.
+type csvResultWriter struct {
+	writer      *csv.Writer
+	wroteHeader bool
+	waveTimes   []string
+}
+
+func (w *csvResultWriter) Write(result *db.GameResult) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	w.waveTimes = w.waveTimes[:0]
+	for _, waveTime := range result.WaveTimes {
+		w.waveTimes = append(w.waveTimes, formatFloat(waveTime))
+	}
+	return w.writer.Write([]string{
+		result.ID.Hex(),
+		result.CreatedAt.UTC().Format(time.RFC3339Nano),
+		spreadsheetText(result.IdempotencyKey),
+		spreadsheetText(result.Player.SteamId),
+		spreadsheetText(result.Player.Name),
+		strconv.FormatBool(result.Hidden),
+		strconv.Itoa(result.WavesSurvived),
+		formatFloat(result.TotalGameTime),
+		formatFloat(result.AverageWaveTime),
+		formatFloat(result.Extra.DamageDealt),
+		strconv.Itoa(result.Extra.EnemiesKilled),
+		formatFloat(result.Extra.EssenceHarvested),
+		formatFloat(result.Extra.EssenceSpent),
+		strconv.Itoa(result.Extra.TowersBuilt),
+		strconv.Itoa(result.Extra.UpgradesPurchased),
+		strings.Join(w.waveTimes, ";"),
+	})
+}
+
+// Flush writes the header too when there was no result to write.
+func (w *csvResultWriter) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *csvResultWriter) writeHeader() error {
+	if w.wroteHeader {
+		return nil
+	}
+	w.wroteHeader = true
+	return w.writer.Write(csvResultColumns)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// spreadsheetText keeps spreadsheets from evaluating the names chosen by players as formulas.
+func spreadsheetText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// exportResponse sets the headers of the export on the first write, so an error found
+// before any result is written is still answered with the error envelope, and extends the
+// write deadline before every write so the server write timeout bounds each write instead
+// of the whole export.
+type exportResponse struct {
+	http.ResponseWriter
+	controller *http.ResponseController
+	format     string
+	started    bool
+}
+
+func (w *exportResponse) start() {
+	if w.started {
+		return
+	}
+	w.started = true
+	header := w.Header()
+	header.Set("Content-Type", exportContentTypes[w.format])
+	header.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="results.%s"`, w.format))
+	header.Set("Cache-Control", "no-store")
+}
+
+func (w *exportResponse) Write(data []byte) (int, error) {
+	w.start()
+	err := w.controller.SetWriteDeadline(time.Now().Add(app.Settings.Api.WriteTimeout.Duration))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// ExportResultsEndpoint streams the stored results matching the query as NDJSON or CSV,
+// oldest first. An export failing once results were written ends early, which is logged.
+func ExportResultsEndpoint(c *routing.Context) error {
+	query := c.Request.URL.Query()
+	params := exportParams{
+		Format:  query.Get("format"),
+		From:    query.Get("from"),
+		To:      query.Get("to"),
+		Season:  query.Get("season"),
+		SteamId: query.Get("steamId"),
+	}
+	var visibleError []FieldError
+	if visible := query.Get("visible"); visible != "" {
+		parsed, err := strconv.ParseBool(visible)
+		if err != nil {
+			visibleError = append(visibleError, FieldError{"visible", FieldInvalidType, "must be a boolean"})
+		}
+		params.Visible = parsed
+	}
+	filter, format, details := params.filter()
+	if details = append(details, visibleError...); len(details) > 0 {
+		return NewValidationError(details)
+	}
+
+	log := requestLogger(c, "admin/results/export").With("format", format)
+	response := &exportResponse{ResponseWriter: c.Response, controller: http.NewResponseController(c.Response), format: format}
+	writer := newResultWriter(format, response)
+	count, err := db.ExportResults(c.Request.Context(), filter, writer.Write)
+	if err == nil {
+		err = writer.Flush()
+	}
+	switch {
+	case err != nil && !response.started:
+		log.Error("Error exporting results", "error", err)
+		return err
+	case err != nil && c.Request.Context().Err() != nil:
+		log.Debug("Export client left", "error", err, "results", count)
+		return nil
+	case err != nil:
+		log.Error("Export ended early", "error", err, "results", count)
+		return nil
+	}
+
+	// An empty NDJSON export has not written anything yet
+	response.start()
+	log.Info("Exported results", "results", count)
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	routing "github.com/go-ozzo/ozzo-routing"
+
+	"bob-leaderboard/app"
+	"bob-leaderboard/db"
+)
+
+// Build information, set with -ldflags "-X main.BuildCommit=... -X main.BuildTime=...".
+// When empty they fall back to the vcs information embedded by the go tool.
+var (
+	BuildCommit = ""
+	BuildTime   = ""
+)
+
+var startedAt = time.Now()
+
+// healthCheckTimeout bounds how long a single check may take.
+const healthCheckTimeout = 5 * time.Second
+
+// HealthCheck is a single named check reported by the health endpoints.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type HealthCheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type HealthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks"`
+}
+
+// livenessChecks only verify that the process is able to handle requests.
+var livenessChecks = []HealthCheck{
+	{"process", func(ctx context.Context) error { return nil }},
+}
+
+// readinessChecks verify the dependencies needed to serve every page.
+var readinessChecks = []HealthCheck{
+	{"mongo", checkMongo},
+	{"templates", checkTemplates},
+	{"roadmap", checkRoadmap},
+}
+
+// LivenessHandler reports that the process is alive.
+func LivenessHandler(c *routing.Context) error {
+	return writeHealthReport(c, runHealthChecks(c.Request.Context(), livenessChecks))
+}
+
+// ReadinessHandler reports whether the server can handle requests, responding
+// with 503 while any of its dependencies is unavailable.
+func ReadinessHandler(c *routing.Context) error {
+	return writeHealthReport(c, runHealthChecks(c.Request.Context(), readinessChecks))
+}
+
+// VersionHandler reports the build and configuration the server is running with.
+func VersionHandler(c *routing.Context) error {
+	commit, buildTime := buildInfo()
+	return c.Write(map[string]interface{}{
+		"commit":        commit,
+		"buildTime":     buildTime,
+		"goVersion":     runtime.Version(),
+		"profile":       app.Profile(),
+		"startedAt":     startedAt.UTC(),
+		"uptimeSeconds": int64(time.Since(startedAt).Seconds()),
+	})
+}
+
+// buildInfo returns the commit and time of the build, from BuildCommit and BuildTime
+// or the vcs information of the go tool.
+func buildInfo() (commit, buildTime string) {
+	commit, buildTime = BuildCommit, BuildTime
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && commit == "":
+				commit = setting.Value
+			case setting.Key == "vcs.time" && buildTime == "":
+				buildTime = setting.Value
+			}
+		}
+	}
+	return commit, buildTime
+}
+
+func runHealthChecks(ctx context.Context, checks []HealthCheck) HealthReport {
+	report := HealthReport{Status: "ok", Checks: make(map[string]HealthCheckResult, len(checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check HealthCheck) {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			checkErr := make(chan error, 1)
+			go func() { checkErr <- check.Check(ctx) }()
+
+			var err error
+			select {
+			case err = <-checkErr:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+
+			result := HealthCheckResult{
+				Status:    "ok",
+				LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = "unavailable"
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[check.Name] = result
+			if err != nil {
+				report.Status = "unavailable"
+			}
+		}(check)
+	}
+	wg.Wait()
+
+	return report
+}
+
+func writeHealthReport(c *routing.Context, report HealthReport) error {
+	c.Response.Header().Set("Cache-Control", "no-store")
+	if report.Status != "ok" {
+		c.Response.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return c.Write(report)
+}
+
+func checkMongo(ctx context.Context) error {
+	health := db.CheckHealth(ctx)
+	if !health.Healthy {
+		return errors.New(health.Error)
+	}
+	return nil
+}
+
+func checkTemplates(ctx context.Context) error {
+	files, err := filepath.Glob("frontend/src/*.gohtml")
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no page templates found in frontend/src")
+	}
+
+	for _, file := range files {
+		if _, err := template.ParseFiles(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkRoadmap passes when the roadmap is cached, otherwise it loads it from the tracker.
+func checkRoadmap(ctx context.Context) error {
+	if _, ok := app.CachedIssues(); ok {
+		return nil
+	}
+	return app.LoadAllIssues(ctx)
+}
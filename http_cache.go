@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	routing "github.com/go-ozzo/ozzo-routing"
+
+	"bob-leaderboard/app/logger"
+)
+
+// revalidateCacheControl lets clients and CDNs store the responses validated by an ETag,
+// as long as they check it on every use. Unchanged responses are then answered with 304
+// without being built again.
+const revalidateCacheControl = "public, no-cache"
+
+// buildTag is part of every ETag so responses cached before a deploy are not reused when
+// the new build renders them differently. Without vcs information it changes on restart.
+var buildTag = sync.OnceValue(func() string {
+	commit, buildTime := buildInfo()
+	if commit == "" {
+		return fmt.Sprint(startedAt.UnixNano())
+	}
+	return commit + buildTime
+})
+
+// etag returns the weak ETag of a response built by this build from the given versions
+// of its data.
+func etag(kind string, versions ...any) string {
+	hash := fnv.New64a()
+	hash.Write([]byte(buildTag()))
+	for _, version := range versions {
+		fmt.Fprintf(hash, "|%v", version)
+	}
+	return fmt.Sprintf(`W/"%s-%x"`, kind, hash.Sum64())
+}
+
+// notModified sets the ETag and Cache-Control headers of the response and answers 304
+// Not Modified when If-None-Match names the same ETag, the handler then has nothing
+// left to write.
+func notModified(c *routing.Context, etag string) bool {
+	header := c.Response.Header()
+	header.Set("ETag", etag)
+	header.Set("Cache-Control", revalidateCacheControl)
+
+	if !etagMatches(c.Request.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	c.Response.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// leaderboardNotModified validates a response built from the leaderboard with its
+// version, the response is built anyway when the version can't be read.
+func leaderboardNotModified(c *routing.Context, log *logger.FieldLogger, kind string) bool {
+	version, err := leaderboard.Version(c.Request.Context())
+	if err != nil {
+		log.Warning("Error reading the leaderboard version", "error", err)
+		return false
+	}
+	return notModified(c, etag(kind, version))
+}
+
+// etagMatches compares the ETags of If-None-Match with etag, weakly as RFC 9110 asks for
+// If-None-Match.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// templateVersion returns the modification time of a page template, so edited templates
+// are served again without a restart.
+func templateVersion(templateName string) time.Time {
+	info, err := os.Stat("frontend/src/" + templateName + ".gohtml")
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
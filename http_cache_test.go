@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"bob-leaderboard/app"
+)
+
+func TestLandingPageETagFollowsTheConfig(t *testing.T) {
+	_, server := useMemoryLeaderboard(t)
+
+	get := func(ifNoneMatch string) *http.Response {
+		t.Helper()
+		request, err := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ifNoneMatch != "" {
+			request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		return response
+	}
+
+	app.Settings.SteamUrl = "https://store.steampowered.com/app/1"
+	cached := get("").Header.Get("ETag")
+	if response := get(cached); response.StatusCode != http.StatusNotModified {
+		t.Fatalf("revalidating the page answered %d, want %d", response.StatusCode, http.StatusNotModified)
+	}
+
+	// A restart with another Steam URL serves the page again
+	app.Settings.SteamUrl = "https://store.steampowered.com/app/2"
+	if response := get(cached); response.StatusCode != http.StatusOK || response.Header.Get("ETag") == cached {
+		t.Errorf("after the Steam URL changed: status %d, ETag %s, want the page with a new ETag", response.StatusCode, response.Header.Get("ETag"))
+	}
+}
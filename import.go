@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	routing "github.com/go-ozzo/ozzo-routing"
+
+	"bob-leaderboard/app"
+	"bob-leaderboard/app/logger"
+	"bob-leaderboard/db"
+)
+
+const (
+	// importBatchSize is the number of results inserted at once.
+	importBatchSize = 500
+	// importErrorLimit is the number of rejected rows listed by an ImportReport, the
+	// others are only counted.
+	importErrorLimit = 100
+	// importMaxBytes bounds the body of an import request.
+	importMaxBytes = 256 << 20
+	// importMaxLineBytes bounds a line of an NDJSON import.
+	importMaxLineBytes = 1 << 20
+)
+
+// ImportRecord is a row of a bulk import: a submission, with the time the game was played.
+type ImportRecord struct {
+	db.GameResultRequestData
+	// CreatedAt is when the game was played, the time of the import when it is missing
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+
+	// WaveTimes and Extra are the fields of the results written by the export, read in
+	// place of the wave durations and stats of the submission
+	WaveTimes []float64              `json:"waveTimes,omitempty"`
+	Extra     *db.ExtraGameStatsData `json:"extra,omitempty"`
+}
+
+// submission returns the submission of the record.
+func (r ImportRecord) submission() db.GameResultRequestData {
+	data := r.GameResultRequestData
+	if data.Waves == nil {
+		data.Waves = r.WaveTimes
+	}
+	if r.Extra != nil {
+		data.ExtraGameStatsData = *r.Extra
+	}
+	return data
+}
+
+// ImportReport summarises a bulk import. With DryRun set nothing is stored and Imported
+// reports how many results would be.
+type ImportReport struct {
+	Rows       int              `json:"rows"`
+	Imported   int              `json:"imported"`
+	Duplicates int              `json:"duplicates"`
+	Rejected   int              `json:"rejected"`
+	DryRun     bool             `json:"dryRun"`
+	Errors     []ImportRowError `json:"errors"`
+}
+
+// ImportRowError is a row of a bulk import which was rejected.
+type ImportRowError struct {
+	Line    int          `json:"line"`
+	Message string       `json:"message"`
+	Details []FieldError `json:"details,omitempty"`
+}
+
+// importReadError is an import which could not be read any further.
+type importReadError struct {
+	line int
+	err  error
+}
+
+func (e *importReadError) Error() string { return fmt.Sprintf("line %d: %v", e.line, e.err) }
+func (e *importReadError) Unwrap() error { return e.err }
+
+type importRow struct {
+	line   int
+	record ImportRecord
+	// problem is set when the row could not be read as a record
+	problem *ImportRowError
+}
+
+// importRows reads the rows of an import one at a time, returning io.EOF after the last.
+type importRows interface {
+	Next() (importRow, error)
+}
+
+func newImportRows(format string, r io.Reader) (importRows, error) {
+	if format == "csv" {
+		return newCSVImportRows(r)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), importMaxLineBytes)
+	return &ndjsonImportRows{scanner: scanner}, nil
+}
+
+// ndjsonImportRows reads a record per line, skipping blank lines.
+type ndjsonImportRows struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *ndjsonImportRows) Next() (importRow, error) {
+	for r.scanner.Scan() {
+		r.line++
+		data := strings.TrimSpace(r.scanner.Text())
+		if data == "" {
+			continue
+		}
+
+		row := importRow{line: r.line}
+		if err := json.Unmarshal([]byte(data), &row.record); err != nil {
+			row.problem = &ImportRowError{Line: r.line, Message: "the row is not a valid JSON object"}
+			if detail, ok := typeErrorDetail(err); ok {
+				row.problem.Message = "the row is invalid"
+				row.problem.Details = []FieldError{detail}
+			}
+		}
+		return row, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return importRow{}, &importReadError{r.line + 1, err}
+	}
+	return importRow{}, io.EOF
+}
+
+// csvImportColumn sets the field of a record, by its JSON path, from a cell.
+type csvImportColumn struct {
+	field string
+	set   func(record *ImportRecord, value string) error
+}
+
+// csvImportColumns are the columns of a CSV import, named like the fields of a submission.
+// The wave durations are in a single column separated by semicolons, the CSV written by
+// the export can be imported too.
+var csvImportColumns = map[string]csvImportColumn{
+	"steamId":           {"player.steamId", func(r *ImportRecord, v string) error { r.Player.SteamId = fromSpreadsheetText(v); return nil }},
+	"steamName":         {"player.steamName", func(r *ImportRecord, v string) error { r.Player.Name = fromSpreadsheetText(v); return nil }},
+	"waveDurations":     {"waveDurations", setWaveDurations},
+	"waveTimes":         {"waveDurations", setWaveDurations},
+	"damageDealt":       {"damageDealt", setFloat(func(r *ImportRecord) *float64 { return &r.DamageDealt })},
+	"enemiesKilled":     {"enemiesKilled", setInt(func(r *ImportRecord) *int { return &r.EnemiesKilled })},
+	"essenceHarvested":  {"essenceHarvested", setFloat(func(r *ImportRecord) *float64 { return &r.EssenceHarvested })},
+	"essenceSpent":      {"essenceSpent", setFloat(func(r *ImportRecord) *float64 { return &r.EssenceSpent })},
+	"towersBuilt":       {"towersBuilt", setInt(func(r *ImportRecord) *int { return &r.TowersBuilt })},
+	"upgradesPurchased": {"upgradesPurchased", setInt(func(r *ImportRecord) *int { return &r.UpgradesPurchased })},
+	"runId":             {"runId", func(r *ImportRecord, v string) error { r.RunId = fromSpreadsheetText(v); return nil }},
+	"createdAt": {"createdAt", func(r *ImportRecord, v string) error {
+		createdAt, err := parseTimeParam(v)
+		if err != nil {
+			return errors.New("must be a date (2006-01-02) or an RFC 3339 time")
+		}
+		r.CreatedAt = &createdAt
+		return nil
+	}},
+}
+
+// csvIgnoredColumns are the columns of the export computed from the others.
+var csvIgnoredColumns = map[string]bool{
+	"id": true, "hidden": true, "wavesSurvived": true, "totalGameTime": true, "averageWaveTime": true,
+}
+
+func setWaveDurations(r *ImportRecord, value string) error {
+	r.Waves = []float64{}
+	for _, duration := range strings.Split(value, ";") {
+		if duration = strings.TrimSpace(duration); duration == "" {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(duration, 64)
+		if err != nil {
+			return errors.New("must be numbers separated by semicolons")
+		}
+		r.Waves = append(r.Waves, parsed)
+	}
+	return nil
+}
+
+func setFloat(field func(*ImportRecord) *float64) func(*ImportRecord, string) error {
+	return func(r *ImportRecord, value string) error {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		*field(r) = parsed
+		return nil
+	}
+}
+
+func setInt(field func(*ImportRecord) *int) func(*ImportRecord, string) error {
+	return func(r *ImportRecord, value string) error {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		*field(r) = parsed
+		return nil
+	}
+}
+
+// fromSpreadsheetText undoes spreadsheetText.
+func fromSpreadsheetText(value string) string {
+	if len(value) > 1 && value[0] == '\'' && spreadsheetText(value[1:]) != value[1:] {
+		return value[1:]
+	}
+	return value
+}
+
+// csvImportRows reads a record per row after the header row naming the columns.
+type csvImportRows struct {
+	reader  *csv.Reader
+	columns []csvImportColumn
+	line    int
+}
+
+func newCSVImportRows(r io.Reader) (*csvImportRows, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, &importReadError{1, errors.New("the header row is missing")}
+	} else if err != nil {
+		return nil, &importReadError{1, err}
+	}
+	reader.ReuseRecord = true
+
+	rows := &csvImportRows{reader: reader, columns: make([]csvImportColumn, len(header)), line: 1}
+	named := map[string]bool{}
+	var unknown []string
+	for i, name := range header {
+		// Spreadsheets may start the file with a byte order mark
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		name = strings.TrimSpace(name)
+		column, ok := csvImportColumns[name]
+		switch {
+		case ok:
+			rows.columns[i] = column
+			named[column.field] = true
+		case !csvIgnoredColumns[name]:
+			unknown = append(unknown, strconv.Quote(name))
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, &importReadError{1, fmt.Errorf("unknown column(s) %s", strings.Join(unknown, ", "))}
+	}
+	for _, field := range []string{"player.steamId", "player.steamName", "waveDurations"} {
+		if !named[field] {
+			return nil, &importReadError{1, fmt.Errorf("the %s column is missing", strings.TrimPrefix(field, "player."))}
+		}
+	}
+	return rows, nil
+}
+
+func (r *csvImportRows) Next() (importRow, error) {
+	cells, err := r.reader.Read()
+	var parseError *csv.ParseError
+	switch {
+	case err == io.EOF:
+		return importRow{}, io.EOF
+	case errors.As(err, &parseError) && errors.Is(err, csv.ErrFieldCount):
+		return importRow{line: parseError.StartLine, problem: &ImportRowError{
+			Line:    parseError.StartLine,
+			Message: fmt.Sprintf("expected %d cells, got %d", len(r.columns), len(cells)),
+		}}, nil
+	case errors.As(err, &parseError):
+		return importRow{}, &importReadError{parseError.StartLine, parseError.Err}
+	case err != nil:
+		return importRow{}, &importReadError{r.line + 1, err}
+	}
+	r.line, _ = r.reader.FieldPos(0)
+	row := importRow{line: r.line}
+
+	var details []FieldError
+	for i, cell := range cells {
+		if r.columns[i].set == nil || strings.TrimSpace(cell) == "" {
+			continue
+		}
+		if err := r.columns[i].set(&row.record, strings.TrimSpace(cell)); err != nil {
+			details = append(details, FieldError{r.columns[i].field, FieldInvalidType, err.Error()})
+		}
+	}
+	if len(details) > 0 {
+		row.problem = &ImportRowError{Line: row.line, Message: "the row is invalid", Details: details}
+	}
+	return row, nil
+}
+
+// importer validates the rows of an import like submissions and inserts their results in
+// batches. Results of a player with the same wave times and stats, or the same runId, as a
+// result already stored or read earlier in the import are duplicates and skipped.
+type importer struct {
+	repository db.LeaderboardRepository
+	report     ImportReport
+	// banned is set for the players whose stored results were read, by steam id
+	banned map[string]bool
+	seen   map[string]bool
+	batch  []*db.GameResult
+}
+
+// importResults imports the rows into the repository, only reporting what would be
+// stored with dryRun set.
+func importResults(ctx context.Context, repository db.LeaderboardRepository, rows importRows, dryRun bool) (ImportReport, error) {
+	im := &importer{
+		repository: repository,
+		report:     ImportReport{DryRun: dryRun, Errors: []ImportRowError{}},
+		banned:     map[string]bool{},
+		seen:       map[string]bool{},
+	}
+
+	for {
+		row, err := rows.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return im.report, err
+		}
+
+		im.report.Rows++
+		if err := im.add(ctx, row); err != nil {
+			return im.report, err
+		}
+		if len(im.batch) >= importBatchSize {
+			if err := im.flush(ctx); err != nil {
+				return im.report, err
+			}
+		}
+	}
+	return im.report, im.flush(ctx)
+}
+
+func (im *importer) add(ctx context.Context, row importRow) error {
+	if row.problem != nil {
+		im.reject(*row.problem)
+		return nil
+	}
+
+	data := row.record.submission()
+	result := db.NewGameResult(data)
+	_, details := validateSubmission(data, result)
+	if createdAt := row.record.CreatedAt; createdAt != nil {
+		result.CreatedAt = createdAt.UTC()
+		if createdAt.After(time.Now()) {
+			details = append(details, FieldError{"createdAt", FieldInvalidValue, "must not be in the future"})
+		}
+	}
+	if len(details) > 0 {
+		im.reject(ImportRowError{row.line, "the row is invalid", details})
+		return nil
+	}
+
+	banned, err := im.loadPlayer(ctx, data.Player.SteamId)
+	if err != nil {
+		return err
+	}
+	if banned {
+		im.reject(ImportRowError{Line: row.line, Message: "player is banned"})
+		return nil
+	}
+
+	keys := importKeys(result)
+	for _, key := range keys {
+		if im.seen[key] {
+			im.report.Duplicates++
+			return nil
+		}
+	}
+	for _, key := range keys {
+		im.seen[key] = true
+	}
+	im.batch = append(im.batch, result)
+	return nil
+}
+
+// loadPlayer reads whether the player is banned and the results they stored, the first
+// time one of their rows is imported.
+func (im *importer) loadPlayer(ctx context.Context, steamId string) (bool, error) {
+	if banned, ok := im.banned[steamId]; ok {
+		return banned, nil
+	}
+
+	banned, err := im.repository.IsBanned(ctx, steamId)
+	if err != nil {
+		return false, err
+	}
+	stored, err := im.repository.FindResultsByPlayer(ctx, steamId)
+	if err != nil {
+		return false, err
+	}
+	for i := range stored {
+		for _, key := range importKeys(&stored[i]) {
+			im.seen[key] = true
+		}
+	}
+	im.banned[steamId] = banned
+	return banned, nil
+}
+
+func (im *importer) reject(problem ImportRowError) {
+	im.report.Rejected++
+	if len(im.report.Errors) < importErrorLimit {
+		im.report.Errors = append(im.report.Errors, problem)
+	}
+}
+
+func (im *importer) flush(ctx context.Context) error {
+	if len(im.batch) == 0 {
+		return nil
+	}
+	if !im.report.DryRun {
+		if err := im.repository.InsertResults(ctx, im.batch); err != nil {
+			return err
+		}
+	}
+	im.report.Imported += len(im.batch)
+	logger.Debug("Imported %d results", im.report.Imported)
+	im.batch = nil
+	return nil
+}
+
+// importKeys identify the game of a result, whenever it was played: by its wave times and
+// stats, and by its idempotency key when it has one.
+func importKeys(result *db.GameResult) []string {
+	keys := []string{fmt.Sprintf("game %q %v %v", result.Player.SteamId, result.WaveTimes, result.Extra)}
+	if result.IdempotencyKey != "" {
+		keys = append(keys, fmt.Sprintf("run %q %q", result.Player.SteamId, result.IdempotencyKey))
+	}
+	return keys
+}
+
+// importBody extends the read deadline of the request before every read, so the server
+// read timeout bounds each read instead of the whole upload.
+type importBody struct {
+	io.Reader
+	controller *http.ResponseController
+}
+
+func (b importBody) Read(data []byte) (int, error) {
+	err := b.controller.SetReadDeadline(time.Now().Add(app.Settings.Api.ReadTimeout.Duration))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, err
+	}
+	return b.Reader.Read(data)
+}
+
+// ImportResultsEndpoint imports the results of the NDJSON or CSV body, validated like
+// submissions, and answers with the ImportReport. A body which cannot be read to the
+// end is rejected, keeping the results of the batches inserted before.
+func ImportResultsEndpoint(c *routing.Context) error {
+	query := c.Request.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+	var details []FieldError
+	if _, ok := exportContentTypes[format]; !ok {
+		details = append(details, FieldError{"format", FieldInvalidValue, "must be ndjson or csv"})
+	}
+	dryRun := false
+	if value := query.Get("dryRun"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			details = append(details, FieldError{"dryRun", FieldInvalidType, "must be a boolean"})
+		}
+		dryRun = parsed
+	}
+	if len(details) > 0 {
+		return NewValidationError(details)
+	}
+
+	log := requestLogger(c, "admin/results/import").With("format", format, "dryRun", dryRun)
+	body := importBody{
+		http.MaxBytesReader(c.Response, c.Request.Body, importMaxBytes),
+		http.NewResponseController(c.Response),
+	}
+	rows, err := newImportRows(format, body)
+	var report ImportReport
+	if err == nil {
+		report, err = importResults(c.Request.Context(), leaderboard, rows, dryRun)
+	}
+	if err == nil {
+		log.Info("Imported results", "rows", report.Rows, "imported", report.Imported, "duplicates", report.Duplicates, "rejected", report.Rejected)
+		return c.Write(report)
+	}
+
+	imported := ""
+	if report.Imported > 0 && !dryRun {
+		log = log.With("imported", report.Imported)
+		imported = fmt.Sprintf(", the %d result(s) before were imported", report.Imported)
+	}
+	var readError *importReadError
+	var maxBytesError *http.MaxBytesError
+	switch {
+	case errors.As(err, &maxBytesError):
+		log.Info("Rejected import", "error", err)
+		return NewAPIError(http.StatusRequestEntityTooLarge, CodeInvalidBody, fmt.Sprintf("the body is larger than %d bytes%s", importMaxBytes, imported))
+	case errors.As(err, &readError):
+		log.Info("Rejected import", "error", err)
+		return NewAPIError(http.StatusBadRequest, CodeInvalidBody, err.Error()+imported)
+	}
+	log.Error("Error importing results", "error", err)
+	return err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"bob-leaderboard/db"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	playedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	source := db.NewMemoryRepository()
+	for _, data := range []db.GameResultRequestData{
+		{
+			ExtraGameStatsData: db.ExtraGameStatsData{DamageDealt: 1520.5, EnemiesKilled: 42, TowersBuilt: 7},
+			Player:             db.SteamUserData{SteamId: "76561198000000001", Name: "-Ada"},
+			Waves:              []float64{30.5, 28, 41.25},
+			RunId:              "run-1",
+		},
+		{
+			Player: db.SteamUserData{SteamId: "76561198000000002", Name: "Grace"},
+			Waves:  []float64{12, 0},
+		},
+	} {
+		result := db.NewGameResult(data)
+		result.CreatedAt = playedAt
+		if _, err := source.InsertResult(ctx, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exported, err := source.Results(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{"ndjson", "csv"} {
+		t.Run(format, func(t *testing.T) {
+			var file bytes.Buffer
+			writer := newResultWriter(format, &file)
+			for i := range exported {
+				if err := writer.Write(&exported[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writer.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			target := db.NewMemoryRepository()
+			for _, want := range []ImportReport{
+				{Rows: 2, Imported: 2},
+				{Rows: 2, Duplicates: 2},
+			} {
+				rows, err := newImportRows(format, bytes.NewReader(file.Bytes()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				report, err := importResults(ctx, target, rows, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if report.Rows != want.Rows || report.Imported != want.Imported || report.Duplicates != want.Duplicates || report.Rejected != 0 {
+					t.Fatalf("report = %+v, want %+v", report, want)
+				}
+			}
+
+			imported, err := target.Results(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, result := range imported {
+				original := exported[i]
+				switch {
+				case result.Player != original.Player:
+					t.Errorf("result %d player = %+v, want %+v", i, result.Player, original.Player)
+				case result.IdempotencyKey != original.IdempotencyKey:
+					t.Errorf("result %d runId = %q, want %q", i, result.IdempotencyKey, original.IdempotencyKey)
+				case !result.CreatedAt.Equal(original.CreatedAt):
+					t.Errorf("result %d createdAt = %v, want %v", i, result.CreatedAt, original.CreatedAt)
+				case result.Extra != original.Extra:
+					t.Errorf("result %d extra = %+v, want %+v", i, result.Extra, original.Extra)
+				case result.WavesSurvived != original.WavesSurvived || result.TotalGameTime != original.TotalGameTime:
+					t.Errorf("result %d waves = %v, want %v", i, result.WaveTimes, original.WaveTimes)
+				}
+			}
+		})
+	}
+}
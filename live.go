@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	routing "github.com/go-ozzo/ozzo-routing"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"bob-leaderboard/app"
+	"bob-leaderboard/app/events"
+	"bob-leaderboard/app/logger"
+	"bob-leaderboard/app/metrics"
+	"bob-leaderboard/db"
+)
+
+// Event types of the live leaderboard.
+const (
+	// EventResultStored is published on resultEvents with the *db.GameResult stored
+	EventResultStored = "result.stored"
+	// EventLeaderboardUpdate is pushed to the live clients with a LiveUpdate
+	EventLeaderboardUpdate = "leaderboard.update"
+)
+
+var (
+	// resultEvents carries the results stored by this instance and, with Live.ChangeStream,
+	// by the other instances as well. The live feed turns them into liveUpdates.
+	resultEvents = events.NewBus()
+	// liveUpdates carries the LiveUpdate pushed to the SSE and WebSocket clients.
+	liveUpdates = events.NewBus()
+)
+
+// liveClientBuffer is how many updates a client may lag behind before missing some.
+const liveClientBuffer = 64
+
+// liveHistoryLength is how many of the last updates an SSE client can resume from.
+const liveHistoryLength = 256
+
+// recentResults is how many result ids the live feed remembers, a result stored by this
+// instance is published twice when the change stream is enabled.
+const recentResults = 1024
+
+// LiveUpdate is pushed to the live clients when a result is stored.
+type LiveUpdate struct {
+	Id    uint64                `json:"id"`
+	Board string                `json:"board"`
+	Entry db.RankingResultsItem `json:"entry"`
+	TopN  int                   `json:"topN"`
+	// Shifted are the entries whose position in the top N changed, the new entry included
+	Shifted []ShiftedEntry `json:"shifted"`
+}
+
+// ShiftedEntry is an entry of the top N which moved because of a new result.
+type ShiftedEntry struct {
+	GameId primitive.ObjectID `json:"gameId"`
+	Player db.SteamUserData   `json:"player"`
+	// Ranking is null when the entry left the top N
+	Ranking *int `json:"ranking"`
+	// PreviousRanking is null when the entry entered the top N
+	PreviousRanking *int `json:"previousRanking"`
+}
+
+// liveHistory keeps the last updates published, for the SSE clients resuming their stream
+// with Last-Event-ID. The update ids are counted by every instance on its own, a client
+// resuming on another instance or after a restart may miss updates.
+var liveHistory updateHistory
+
+type updateHistory struct {
+	mu      sync.Mutex
+	updates []LiveUpdate
+}
+
+func (h *updateHistory) add(update LiveUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.updates = append(h.updates, update)
+	if len(h.updates) > liveHistoryLength {
+		h.updates = h.updates[len(h.updates)-liveHistoryLength:]
+	}
+}
+
+// since returns the kept updates published after the one with the id, oldest first.
+func (h *updateHistory) since(id uint64) []LiveUpdate {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, update := range h.updates {
+		if update.Id > id {
+			return append([]LiveUpdate(nil), h.updates[i:]...)
+		}
+	}
+	return nil
+}
+
+// publishLiveUpdate pushes an update to the live clients.
+func publishLiveUpdate(update LiveUpdate) {
+	liveHistory.add(update)
+	missed := liveUpdates.Publish(events.Event{Type: EventLeaderboardUpdate, Data: update})
+	metrics.LiveUpdatesDropped.Add(float64(missed))
+}
+
+// publishStoredResult hands a stored result over to the live feed. A result the feed
+// misses is missing from the materialised ranking as well, which is rebuilt to pick it up.
+func publishStoredResult(result db.GameResult) {
+	if missed := resultEvents.Publish(events.Event{Type: EventResultStored, Data: &result}); missed > 0 {
+		metrics.ResultEventsDropped.Add(float64(missed))
+		requestRankingRebuild()
+	}
+}
+
+// liveFeed ranks the stored results and works out how the top N changed.
+type liveFeed struct {
+	repository db.LeaderboardRepository
+	topN       int
+	lastId     uint64
+	seen       map[primitive.ObjectID]bool
+	seenOrder  []primitive.ObjectID
+}
+
+func newLiveFeed(repository db.LeaderboardRepository, topN int) *liveFeed {
+	return &liveFeed{repository: repository, topN: topN, seen: map[primitive.ObjectID]bool{}}
+}
+
+// runLiveFeed publishes a LiveUpdate for every result published on resultEvents. It is
+// the only reader of resultEvents: a result is added to the materialised ranking before
+// its update is built, so the update reads it from there.
+func runLiveFeed(ctx context.Context) {
+	feed := newLiveFeed(leaderboard, app.Settings.Live.TopN)
+	results := resultEvents.Subscribe(256)
+	defer results.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-results.Events():
+			if !ok {
+				return
+			}
+			result := event.Data.(*db.GameResult)
+			if rankingCache != nil {
+				rankingCache.Add(*result)
+			}
+			update, ok, err := feed.update(ctx, result)
+			if err != nil {
+				logger.With("gameId", result.ID.Hex()).Warning("Error ranking a live update", "error", err)
+				continue
+			}
+			if ok {
+				publishLiveUpdate(update)
+			}
+		}
+	}
+}
+
+// update returns the LiveUpdate of a stored result, or false when the result was already
+// published or is not on the leaderboard. A result is only remembered once it is ranked,
+// so it is published when it comes again after not being found.
+func (f *liveFeed) update(ctx context.Context, result *db.GameResult) (LiveUpdate, bool, error) {
+	if result.Hidden || f.seen[result.ID] {
+		return LiveUpdate{}, false, nil
+	}
+
+	entries, err := f.repository.GetRankingsPage(ctx, db.GetRankingsOptions{
+		Filters:               map[string]any{"gameId": result.ID.Hex()},
+		GetRankingsPagination: db.GetRankingsPagination{Size: 1},
+	})
+	if err != nil || len(entries.Data) == 0 {
+		return LiveUpdate{}, false, err
+	}
+	f.remember(result.ID)
+
+	f.lastId++
+	update := LiveUpdate{
+		Id:      f.lastId,
+		Board:   "global",
+		Entry:   entries.Data[0],
+		TopN:    f.topN,
+		Shifted: []ShiftedEntry{},
+	}
+	if update.Entry.Ranking >= f.topN {
+		return update, true, nil
+	}
+
+	// The top N before the result is the top N+1 without it, so no state has to be kept
+	// in line with the results stored by the other instances. Results stored meanwhile are
+	// already ranked, the shifts of a burst of results are approximate.
+	top, err := f.repository.GetRankingsPage(ctx, db.GetRankingsOptions{
+		GetRankingsPagination: db.GetRankingsPagination{Size: f.topN + 1},
+	})
+	if err != nil {
+		return LiveUpdate{}, false, err
+	}
+	update.Shifted = shiftedEntries(top.Data, result.ID, f.topN)
+
+	return update, true, nil
+}
+
+func (f *liveFeed) remember(id primitive.ObjectID) {
+	f.seen[id] = true
+	f.seenOrder = append(f.seenOrder, id)
+	if len(f.seenOrder) > recentResults {
+		delete(f.seen, f.seenOrder[0])
+		f.seenOrder = f.seenOrder[1:]
+	}
+}
+
+// shiftedEntries compares the top N entries with the ones before the new entry was
+// ranked, given the top N+1 entries including it.
+func shiftedEntries(top []db.RankingResultsItem, newEntry primitive.ObjectID, topN int) []ShiftedEntry {
+	shifted := []ShiftedEntry{}
+	previous := 0
+	for i := range top {
+		entry := top[i]
+		shift := ShiftedEntry{GameId: entry.GameId, Player: entry.Player}
+		if entry.Ranking < topN {
+			shift.Ranking = &top[i].Ranking
+		}
+		if entry.GameId != newEntry {
+			if previous < topN {
+				previousRanking := previous
+				shift.PreviousRanking = &previousRanking
+			}
+			previous++
+		}
+
+		if shift.Ranking == nil && shift.PreviousRanking == nil {
+			continue
+		}
+		if shift.Ranking != nil && shift.PreviousRanking != nil && *shift.Ranking == *shift.PreviousRanking {
+			continue
+		}
+		shifted = append(shifted, shift)
+	}
+	return shifted
+}
+
+// runResultStream publishes the results stored by every instance from a change stream,
+// resuming it with a backoff when it fails.
+func runResultStream(ctx context.Context) {
+	var stream db.ResultStream
+	backoff := app.Settings.Mongo.Connect.InitialBackoff.Duration
+
+	for {
+		err := stream.Watch(ctx, func(result *db.GameResult) {
+			publishStoredResult(*result)
+			backoff = app.Settings.Mongo.Connect.InitialBackoff.Duration
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Warning("Results change stream stopped, resuming in %s: %v", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, app.Settings.Mongo.Connect.MaxBackoff.Duration)
+	}
+}
+
+// liveBoard returns the board of a live endpoint, or the 404 error for an unknown board.
+func liveBoard(c *routing.Context) (string, error) {
+	board := c.Param("board")
+	if !leaderboards[board] {
+		return "", NewAPIError(http.StatusNotFound, CodeNotFound, fmt.Sprintf("unknown leaderboard %q", board))
+	}
+	return board, nil
+}
+
+// LiveEventsEndpoint streams the LiveUpdate of the board as Server-Sent Events, with a
+// comment sent every Live.Heartbeat to keep proxies from closing the connection. A client
+// reconnecting with Last-Event-ID is first sent the kept updates it missed.
+func LiveEventsEndpoint(c *routing.Context) error {
+	if _, err := liveBoard(c); err != nil {
+		return err
+	}
+
+	response := http.NewResponseController(c.Response)
+	writeTimeout := app.Settings.Api.WriteTimeout.Duration
+	write := func(message string) error {
+		// The server write timeout would end the stream, it is extended for every message
+		if err := response.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		if _, err := c.Response.Write([]byte(message)); err != nil {
+			return err
+		}
+		return response.Flush()
+	}
+
+	updates := liveUpdates.Subscribe(liveClientBuffer)
+	defer updates.Close()
+	metrics.LiveClients.WithLabelValues("sse").Inc()
+	defer metrics.LiveClients.WithLabelValues("sse").Dec()
+
+	header := c.Response.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-store")
+	header.Set("X-Accel-Buffering", "no")
+	c.Response.WriteHeader(http.StatusOK)
+
+	log := requestLogger(c, "v1/leaderboards/events")
+	if err := write(": connected\n\n"); err != nil {
+		log.Debug("Live client left", "error", err)
+		return nil
+	}
+
+	// The updates are read from the history after subscribing, the ones published
+	// meanwhile are received twice and skipped when they come through the subscription
+	var replayed uint64
+	if lastId, err := strconv.ParseUint(c.Request.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		for _, update := range liveHistory.since(lastId) {
+			message, err := liveEventMessage(EventLeaderboardUpdate, update)
+			if err != nil {
+				return err
+			}
+			if err := write(message); err != nil {
+				log.Debug("Live client left", "error", err)
+				return nil
+			}
+			replayed = update.Id
+		}
+	}
+
+	heartbeat := time.NewTicker(app.Settings.Live.Heartbeat.Duration)
+	defer heartbeat.Stop()
+
+	for {
+		var message string
+		select {
+		case <-c.Request.Context().Done():
+			return nil
+		case event, ok := <-updates.Events():
+			if !ok {
+				return nil
+			}
+			update := event.Data.(LiveUpdate)
+			if update.Id <= replayed {
+				continue
+			}
+			var err error
+			if message, err = liveEventMessage(event.Type, update); err != nil {
+				return err
+			}
+		case <-heartbeat.C:
+			message = ": ping\n\n"
+		}
+
+		if err := write(message); err != nil {
+			log.Debug("Live client left", "error", err, "dropped", updates.Dropped())
+			return nil
+		}
+	}
+}
+
+// liveEventMessage formats an update as a Server-Sent Event, with its id as the event id.
+func liveEventMessage(eventType string, update LiveUpdate) (string, error) {
+	data, err := json.Marshal(update)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", update.Id, eventType, data), nil
+}
+
+// liveMessage is a message sent to the WebSocket clients.
+type liveMessage struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+var liveUpgrader = websocket.Upgrader{
+	ReadBufferSize:  512,
+	WriteBufferSize: 4096,
+	// The updates are public like the rankings, overlays connect from any origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// LiveWebSocketEndpoint pushes the LiveUpdate of the board as JSON messages over a
+// WebSocket, pinging the client every Live.Heartbeat.
+func LiveWebSocketEndpoint(c *routing.Context) error {
+	if _, err := liveBoard(c); err != nil {
+		return err
+	}
+
+	conn, err := liveUpgrader.Upgrade(c.Response, c.Request, nil)
+	if err != nil {
+		// The upgrader already answered with the error
+		c.Abort()
+		return nil
+	}
+	defer conn.Close()
+
+	updates := liveUpdates.Subscribe(liveClientBuffer)
+	defer updates.Close()
+	metrics.LiveClients.WithLabelValues("websocket").Inc()
+	defer metrics.LiveClients.WithLabelValues("websocket").Dec()
+
+	log := requestLogger(c, "v1/leaderboards/ws")
+	heartbeat := app.Settings.Live.Heartbeat.Duration
+	writeTimeout := app.Settings.Api.WriteTimeout.Duration
+
+	// The clients have nothing to send, reading only handles the control frames and
+	// notices when the client is gone
+	closed := make(chan struct{})
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(2 * heartbeat))
+	})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			log.Debug("Live client left", "dropped", updates.Dropped())
+			return nil
+		case event, ok := <-updates.Events():
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+					time.Now().Add(writeTimeout))
+				return nil
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			if err := conn.WriteJSON(liveMessage{event.Type, event.Data}); err != nil {
+				log.Debug("Live client left", "error", err, "dropped", updates.Dropped())
+				return nil
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)); err != nil {
+				log.Debug("Live client left", "error", err, "dropped", updates.Dropped())
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"bob-leaderboard/app/events"
+	"bob-leaderboard/db"
+)
+
+// rankedEntries returns entries ranked in the order of their ids.
+func rankedEntries(ids ...primitive.ObjectID) []db.RankingResultsItem {
+	entries := make([]db.RankingResultsItem, len(ids))
+	for i, id := range ids {
+		entries[i] = db.RankingResultsItem{GameId: id, Ranking: i}
+	}
+	return entries
+}
+
+func TestShiftedEntries(t *testing.T) {
+	const topN = 3
+	a, b, c, entry := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	ranking := func(r int) *int { return &r }
+
+	tests := []struct {
+		name    string
+		top     []db.RankingResultsItem
+		shifted []ShiftedEntry
+	}{
+		{"first", rankedEntries(entry, a, b, c), []ShiftedEntry{
+			{GameId: entry, Ranking: ranking(0)},
+			{GameId: a, Ranking: ranking(1), PreviousRanking: ranking(0)},
+			{GameId: b, Ranking: ranking(2), PreviousRanking: ranking(1)},
+			{GameId: c, PreviousRanking: ranking(2)},
+		}},
+		// The last position of the top N pushes a single entry out
+		{"last of the top N", rankedEntries(a, b, entry, c), []ShiftedEntry{
+			{GameId: entry, Ranking: ranking(2)},
+			{GameId: c, PreviousRanking: ranking(2)},
+		}},
+		{"right after the top N", rankedEntries(a, b, c, entry), []ShiftedEntry{}},
+		{"board shorter than the top N", rankedEntries(a, entry), []ShiftedEntry{
+			{GameId: entry, Ranking: ranking(1)},
+		}},
+	}
+
+	for _, test := range tests {
+		shifted := shiftedEntries(test.top, entry, topN)
+		if len(shifted) != len(test.shifted) {
+			t.Errorf("%s: %d shifted entries, want %d", test.name, len(shifted), len(test.shifted))
+			continue
+		}
+		for i, shift := range shifted {
+			want := test.shifted[i]
+			if shift.GameId != want.GameId || !sameRanking(shift.Ranking, want.Ranking) || !sameRanking(shift.PreviousRanking, want.PreviousRanking) {
+				t.Errorf("%s: shift %d is %s from %s to %s, want %s from %s to %s", test.name, i,
+					shift.GameId.Hex(), formatRanking(shift.PreviousRanking), formatRanking(shift.Ranking),
+					want.GameId.Hex(), formatRanking(want.PreviousRanking), formatRanking(want.Ranking))
+			}
+		}
+	}
+}
+
+func sameRanking(a, b *int) bool {
+	return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+}
+
+func formatRanking(ranking *int) string {
+	if ranking == nil {
+		return "out"
+	}
+	return strconv.Itoa(*ranking)
+}
+
+// waitForSubscribers waits until the bus has a subscriber, so the events published next
+// are received.
+func waitForSubscribers(t *testing.T, bus *events.Bus, count int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for bus.Subscribers() < count {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscribers, want %d", bus.Subscribers(), count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// readLiveEvents reads the ids of the next count events of an SSE stream.
+func readLiveEvents(t *testing.T, stream *bufio.Reader, count int) []string {
+	t.Helper()
+	var ids []string
+	for len(ids) < count {
+		line, err := stream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading the events: %v", err)
+		}
+		if id, ok := strings.CutPrefix(strings.TrimSpace(line), "id: "); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func TestLiveEventsResume(t *testing.T) {
+	_, server := useMemoryLeaderboard(t)
+	liveHistory = updateHistory{}
+	t.Cleanup(func() { liveHistory = updateHistory{} })
+
+	for id := uint64(1); id <= 3; id++ {
+		publishLiveUpdate(LiveUpdate{Id: id, Board: "global"})
+	}
+
+	request, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/leaderboards/global/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Last-Event-ID", "1")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	stream := bufio.NewReader(response.Body)
+
+	if ids := readLiveEvents(t, stream, 2); ids[0] != "2" || ids[1] != "3" {
+		t.Fatalf("resumed with the updates %v, want 2 and 3", ids)
+	}
+
+	// An update published while the history was read is not sent twice
+	waitForSubscribers(t, liveUpdates, 1)
+	publishLiveUpdate(LiveUpdate{Id: 3, Board: "global"})
+	publishLiveUpdate(LiveUpdate{Id: 4, Board: "global"})
+	if ids := readLiveEvents(t, stream, 1); ids[0] != "4" {
+		t.Fatalf("after the resumed updates got %v, want 4", ids)
+	}
+}
+
+func TestLiveWebSocketUpdate(t *testing.T) {
+	_, server := useMemoryLeaderboard(t, db.GameResultRequestData{
+		Player: db.SteamUserData{SteamId: "76561198000000001", Name: "Ada"},
+		Waves:  []float64{30, 30, 30},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var feed sync.WaitGroup
+	feed.Add(1)
+	go func() {
+		defer feed.Done()
+		runLiveFeed(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		feed.Wait()
+	})
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/v1/leaderboards/global/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitForSubscribers(t, resultEvents, 1)
+	waitForSubscribers(t, liveUpdates, 1)
+
+	var submitted SubmittedResult
+	response := apiRequest(t, http.MethodPost, server.URL+"/api/v1/results", db.GameResultRequestData{
+		Player: db.SteamUserData{SteamId: "76561198000000002", Name: "Grace"},
+		Waves:  []float64{30, 30, 30, 30},
+	}, nil, &submitted)
+	if response.StatusCode != http.StatusCreated {
+		t.Fatalf("submission answered %d", response.StatusCode)
+	}
+
+	var message struct {
+		Type string     `json:"type"`
+		Data LiveUpdate `json:"data"`
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatal(err)
+	}
+	update := message.Data
+	if message.Type != EventLeaderboardUpdate || update.Entry.GameId != submitted.EntryId || update.Entry.Ranking != 0 {
+		t.Fatalf("received %s %+v, want the update of the submission ranked first", message.Type, update)
+	}
+	if len(update.Shifted) != 2 || update.Shifted[0].GameId != submitted.EntryId || update.Shifted[1].Player.Name != "Ada" {
+		t.Errorf("shifted = %+v, want the submission and Ada moving down", update.Shifted)
+	}
+}
+
+func TestLiveFeedWaitsForTheRanking(t *testing.T) {
+	ctx := context.Background()
+	repository := db.NewMemoryRepository()
+	feed := newLiveFeed(repository, 10)
+
+	// A result coming from the change stream before it can be read is not lost
+	result := db.NewGameResult(db.GameResultRequestData{
+		Player: db.SteamUserData{SteamId: "76561198000000001", Name: "Ada"},
+		Waves:  []float64{30, 30},
+	})
+	result.ID = primitive.NewObjectID()
+	if _, ok, err := feed.update(ctx, result); err != nil || ok {
+		t.Fatalf("update of an unranked result = %v, %v, want none", ok, err)
+	}
+
+	if _, err := repository.InsertResult(ctx, result); err != nil {
+		t.Fatal(err)
+	}
+	update, ok, err := feed.update(ctx, result)
+	if err != nil || !ok || update.Entry.GameId != result.ID {
+		t.Fatalf("update once ranked = %+v, %v, %v, want the update of the result", update, ok, err)
+	}
+	if _, ok, _ := feed.update(ctx, result); ok {
+		t.Error("the result was published twice")
+	}
+}
+
+func TestDroppedResultRequestsRebuild(t *testing.T) {
+	// A feed which doesn't read misses the results published
+	results := resultEvents.Subscribe(0)
+	defer results.Close()
+	select {
+	case <-rankingRebuildRequests:
+	default:
+	}
+
+	publishStoredResult(db.GameResult{})
+	publishStoredResult(db.GameResult{})
+	if results.Dropped() != 2 {
+		t.Fatalf("dropped %d results, want 2", results.Dropped())
+	}
+	select {
+	case <-rankingRebuildRequests:
+	default:
+		t.Fatal("no rebuild was requested for the dropped results")
+	}
+}
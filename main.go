@@ -2,12 +2,12 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"html/template"
 	"net/http"
 	"os"
 
 	routing "github.com/go-ozzo/ozzo-routing"
-	"github.com/go-ozzo/ozzo-routing/access"
 	"github.com/go-ozzo/ozzo-routing/auth"
 	"github.com/go-ozzo/ozzo-routing/content"
 	"github.com/go-ozzo/ozzo-routing/fault"
@@ -16,7 +16,7 @@ import (
 
 	"bob-leaderboard/app"
 	"bob-leaderboard/app/logger"
-	"bob-leaderboard/db"
+	"bob-leaderboard/app/metrics"
 )
 
 type SharedPageData struct {
@@ -35,7 +35,7 @@ type RoadMapPage struct {
 
 func AuthHandler(c *routing.Context) error {
 	return auth.Bearer(func(c *routing.Context, token string) (auth.Identity, error) {
-		if token == os.Getenv("API_SECRET") {
+		if token == app.Settings.Api.Secret {
 			return auth.Identity("LeaderboardApi"), nil
 		}
 		return nil, errors.New("invalid credential")
@@ -43,20 +43,30 @@ func AuthHandler(c *routing.Context) error {
 }
 
 func main() {
-	app.Init()
+	if err := app.Init(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
 
-	db.CreateConnection(
-		os.Getenv("MONGO_URI"),
-		os.Getenv("MONGO_DATABASE_NAME"),
-	)
+	if err := runCommand(os.Args[1:]); err != nil {
+		logger.Critical("%v", err)
+		exit(1)
+	}
+	exit(0)
+}
 
+// newRouter sets up the routes of the web server.
+func newRouter() *routing.Router {
 	router := routing.New()
 
 	router.Use(
-		access.Logger(logger.Debug),
+		logger.RequestIDHandler,
+		logger.AccessLogger,
+		metrics.Middleware,
 		slash.Remover(http.StatusMovedPermanently),
-		fault.Recovery(logger.Error),
-		fault.ErrorHandler(logger.Error),
+		fault.Recovery(logger.Error, convertError),
+		fault.ErrorHandler(nil, convertError),
+		logger.ErrorLogger,
 		fault.PanicHandler(logger.Error),
 	)
 
@@ -67,34 +77,65 @@ func main() {
 
 	api.Use(content.TypeNegotiator(content.JSON))
 
+	api.Get("/openapi.json", OpenAPIHandler)
 	api.Post("/webhooks/linear", app.HandleLinearWebhooks)
-	api.Post("/rankings", GetRankings)
-	api.Post("/rankings/game-result", AuthHandler, PutResultEndpoint)
+	api.Post("/admin/diagnostics/rankings", AdminAuthHandler, RankingDiagnosticsEndpoint)
+	api.Post("/admin/rankings/rebuild", AdminAuthHandler, RebuildRankingsEndpoint)
+	api.Get("/admin/results/export", AdminAuthHandler, ExportResultsEndpoint)
+	api.Post("/admin/results/import", AdminAuthHandler, ImportResultsEndpoint)
+
+	v1 := api.Group("/v1")
+	v1.Get("/leaderboards/<board>/entries", GetLeaderboardEntries)
+	v1.Get("/leaderboards/<board>/events", LiveEventsEndpoint)
+	v1.Get("/leaderboards/<board>/ws", LiveWebSocketEndpoint)
+	v1.Get("/results/<id>", GetResultEndpoint)
+	v1.Post("/results", AuthHandler, PostResultEndpoint)
+
+	// Deprecated routes kept for the game builds already shipped
+	api.Post("/rankings", Deprecated("/api/v1/leaderboards/global/entries"), GetRankings)
+	api.Post("/rankings/game-result", Deprecated("/api/v1/results"), AuthHandler, PutResultEndpoint)
+
+	router.Get("/api/docs", APIDocsPage)
+
+	router.Get("/healthz", content.TypeNegotiator(content.JSON), LivenessHandler)
+	router.Get("/readyz", content.TypeNegotiator(content.JSON), ReadinessHandler)
+	router.Get("/version", content.TypeNegotiator(content.JSON), VersionHandler)
 
 	router.Get("/", func(c *routing.Context) error {
+		// The page renders config values, which change with a restart under the same build
+		if notModified(c, etag("index", templateVersion("index"), app.Settings.SteamUrl)) {
+			return nil
+		}
+
 		data := LandingPage{
 			SharedPageData{
 				"Bastion Of Beginnings",
 				".",
-				app.Config.GetString("SteamUrl"),
+				app.Settings.SteamUrl,
 			},
 		}
 		return CreatePageTemplate(c, "index", data)
 	})
 	router.Get("/roadmap", func(c *routing.Context) error {
-		if app.IssuesData == nil {
-			if err := app.LoadAllIssues(); err != nil {
+		issues, ok := app.CachedIssues()
+		if !ok {
+			if err := app.LoadAllIssues(c.Request.Context()); err != nil {
 				return err
 			}
+			issues, _ = app.CachedIssues()
+		}
+		// Every instance loads its own roadmap, so its version only means something here
+		if notModified(c, etag("roadmap", startedAt.UnixNano(), app.RoadmapVersion(), templateVersion("roadmap"))) {
+			return nil
 		}
 
 		data := RoadMapPage{
 			SharedPageData{
 				"RoadMap",
 				"...",
-				app.Config.GetString("SteamUrl"),
+				app.Settings.SteamUrl,
 			},
-			*app.IssuesData,
+			issues,
 		}
 
 		return CreatePageTemplate(c, "roadmap", data)
@@ -105,13 +146,7 @@ func main() {
 		"/images": "/public/images/",
 	}))
 
-	http.Handle("/", router)
-
-	listenAddr := app.Config.GetString("Api.ListenAddr")
-
-	logger.Debug("Listening on: http://localhost%s", listenAddr)
-
-	http.ListenAndServe(listenAddr, nil)
+	return router
 }
 
 func CreatePageTemplate(c *routing.Context, templateName string, data any) error {
@@ -0,0 +1,310 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	routing "github.com/go-ozzo/ozzo-routing"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"bob-leaderboard/app"
+	"bob-leaderboard/db"
+)
+
+// openAPISpec describes the api routes, it is served at /api/openapi.json and kept in
+// line with the handlers by `bob-leaderboard openapi check`.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// openAPISchemaTypes are the Go types read and written by the handlers, by the name of
+// the schema describing them. Version and Status are written from maps and not checked.
+var openAPISchemaTypes = map[string]reflect.Type{
+	"Error":                   reflect.TypeOf(APIError{}),
+	"FieldError":              reflect.TypeOf(FieldError{}),
+	"SteamUserData":           reflect.TypeOf(db.SteamUserData{}),
+	"ExtraGameStatsData":      reflect.TypeOf(db.ExtraGameStatsData{}),
+	"GameResultRequestData":   reflect.TypeOf(db.GameResultRequestData{}),
+	"SubmittedResult":         reflect.TypeOf(SubmittedResult{}),
+	"GameResult":              reflect.TypeOf(db.GameResult{}),
+	"GetRankingsOptions":      reflect.TypeOf(db.GetRankingsOptions{}),
+	"RankingResultsItem":      reflect.TypeOf(db.RankingResultsItem{}),
+	"PaginatedRankingResults": reflect.TypeOf(db.PaginatedRankingResults{}),
+	"LiveUpdate":              reflect.TypeOf(LiveUpdate{}),
+	"ShiftedEntry":            reflect.TypeOf(ShiftedEntry{}),
+	"ExplainSummary":          reflect.TypeOf(db.ExplainSummary{}),
+	"RankingDiagnostics":      reflect.TypeOf(db.RankingDiagnostics{}),
+	"RankingRebuild":          reflect.TypeOf(RankingRebuild{}),
+	"ImportRecord":            reflect.TypeOf(ImportRecord{}),
+	"ImportReport":            reflect.TypeOf(ImportReport{}),
+	"ImportRowError":          reflect.TypeOf(ImportRowError{}),
+	"HealthCheckResult":       reflect.TypeOf(HealthCheckResult{}),
+	"HealthReport":            reflect.TypeOf(HealthReport{}),
+}
+
+// undocumentedRoutes are the routes serving pages and files rather than the api.
+var undocumentedRoutes = map[string]bool{
+	"/":                 true,
+	"/roadmap":          true,
+	"/*":                true,
+	"/api/docs":         true,
+	"/api/openapi.json": true,
+}
+
+// OpenAPIHandler serves the OpenAPI document of the api.
+func OpenAPIHandler(c *routing.Context) error {
+	c.Response.Header().Set("Content-Type", "application/json")
+	_, err := c.Response.Write(openAPISpec)
+	return err
+}
+
+// APIDocsPage renders the OpenAPI document for people.
+func APIDocsPage(c *routing.Context) error {
+	data := LandingPage{
+		SharedPageData{
+			"API Documentation",
+			"Leaderboard API of Bastion Of Beginnings.",
+			app.Settings.SteamUrl,
+		},
+	}
+	return CreatePageTemplate(c, "api-docs", data)
+}
+
+type openAPIDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]*openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+// openAPISchema is the part of a schema object compared with the Go types.
+type openAPISchema struct {
+	Ref        string                    `json:"$ref"`
+	Type       string                    `json:"type"`
+	Properties map[string]*openAPISchema `json:"properties"`
+	Items      *openAPISchema            `json:"items"`
+	AllOf      []*openAPISchema          `json:"allOf"`
+	// AdditionalProperties is either a boolean or the schema of the values of a map
+	AdditionalProperties json.RawMessage `json:"additionalProperties"`
+}
+
+// checkOpenAPI compares the OpenAPI document with the routes of the router and the Go
+// types of openAPISchemaTypes, and returns every difference found.
+func checkOpenAPI(router *routing.Router) []error {
+	var spec openAPIDocument
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		return []error{fmt.Errorf("openapi.json: %w", err)}
+	}
+
+	var problems []error
+
+	routed := map[string]bool{}
+	for _, route := range router.Routes() {
+		if undocumentedRoutes[route.Path()] {
+			continue
+		}
+		path, method := openAPIPath(route.Path()), strings.ToLower(route.Method())
+		routed[method+" "+path] = true
+		if _, ok := spec.Paths[path][method]; !ok {
+			problems = append(problems, fmt.Errorf("%s %s is routed but not documented", route.Method(), path))
+		}
+	}
+	for path, operations := range spec.Paths {
+		for method := range operations {
+			if !routed[method+" "+path] {
+				problems = append(problems, fmt.Errorf("%s %s is documented but not routed", strings.ToUpper(method), path))
+			}
+		}
+	}
+
+	checker := openAPIChecker{schemas: spec.Components.Schemas}
+	for name, t := range openAPISchemaTypes {
+		schema, ok := spec.Components.Schemas[name]
+		if !ok {
+			problems = append(problems, fmt.Errorf("schema %s of %s is missing", name, t))
+			continue
+		}
+		problems = append(problems, checker.compare(name, schema, t)...)
+	}
+
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Error() < problems[j].Error() })
+	return problems
+}
+
+// openAPIPath turns the parameters of a route path, like <board>, into {board}.
+func openAPIPath(path string) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(path, '<')
+		end := strings.IndexByte(path, '>')
+		if start < 0 || end < start {
+			b.WriteString(path)
+			return b.String()
+		}
+		name, _, _ := strings.Cut(path[start+1:end], ":")
+		b.WriteString(path[:start] + "{" + name + "}")
+		path = path[end+1:]
+	}
+}
+
+type openAPIChecker struct {
+	schemas map[string]*openAPISchema
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	objectIDType   = reflect.TypeOf(primitive.ObjectID{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// compare checks that the schema found at the path describes the JSON encoding of t.
+func (ch openAPIChecker) compare(path string, schema *openAPISchema, t reflect.Type) []error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		if bound, ok := openAPISchemaTypes[name]; ok {
+			if bound != t {
+				return []error{fmt.Errorf("%s: refers to %s but is a %s", path, name, t)}
+			}
+			return nil
+		}
+		referenced, ok := ch.schemas[name]
+		if !ok {
+			return []error{fmt.Errorf("%s: refers to the missing schema %s", path, name)}
+		}
+		return ch.compare(path, referenced, t)
+	}
+
+	switch {
+	case t == rawMessageType, t.Kind() == reflect.Interface:
+		return nil
+	case t == timeType, t == objectIDType:
+		return ch.expectType(path, schema, t, "string")
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return ch.expectType(path, schema, t, "string")
+	case reflect.Bool:
+		return ch.expectType(path, schema, t, "boolean")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return ch.expectType(path, schema, t, "integer")
+	case reflect.Float32, reflect.Float64:
+		return ch.expectType(path, schema, t, "number")
+	case reflect.Slice, reflect.Array:
+		if problems := ch.expectType(path, schema, t, "array"); problems != nil {
+			return problems
+		}
+		if schema.Items == nil {
+			return []error{fmt.Errorf("%s: the items of the array are not described", path)}
+		}
+		return ch.compare(path+"[]", schema.Items, t.Elem())
+	case reflect.Map:
+		if problems := ch.expectType(path, schema, t, "object"); problems != nil {
+			return problems
+		}
+		var values openAPISchema
+		if json.Unmarshal(schema.AdditionalProperties, &values) != nil {
+			return nil
+		}
+		return ch.compare(path+"{}", &values, t.Elem())
+	case reflect.Struct:
+		return ch.compareStruct(path, schema, t)
+	}
+
+	return []error{fmt.Errorf("%s: %s can not be described", path, t)}
+}
+
+func (ch openAPIChecker) expectType(path string, schema *openAPISchema, t reflect.Type, expected string) []error {
+	if schema.Type != expected {
+		return []error{fmt.Errorf("%s: is a %s (%s) but the spec says %q", path, t, expected, schema.Type)}
+	}
+	return nil
+}
+
+func (ch openAPIChecker) compareStruct(path string, schema *openAPISchema, t reflect.Type) []error {
+	if schema.Type != "object" && len(schema.AllOf) == 0 {
+		return []error{fmt.Errorf("%s: is a %s (object) but the spec says %q", path, t, schema.Type)}
+	}
+
+	properties := ch.properties(schema)
+	fields := jsonFields(t)
+
+	var problems []error
+	for name, field := range fields {
+		property, ok := properties[name]
+		if !ok {
+			problems = append(problems, fmt.Errorf("%s.%s: is a field of %s but not a property", path, name, t))
+			continue
+		}
+		problems = append(problems, ch.compare(path+"."+name, property, field)...)
+	}
+	for name := range properties {
+		if _, ok := fields[name]; !ok {
+			problems = append(problems, fmt.Errorf("%s.%s: is a property but not a field of %s", path, name, t))
+		}
+	}
+	return problems
+}
+
+// properties returns the properties of an object schema, merging the ones of allOf.
+func (ch openAPIChecker) properties(schema *openAPISchema) map[string]*openAPISchema {
+	if schema.Ref != "" {
+		if referenced, ok := ch.schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]; ok {
+			return ch.properties(referenced)
+		}
+		return nil
+	}
+
+	properties := map[string]*openAPISchema{}
+	for _, part := range schema.AllOf {
+		for name, property := range ch.properties(part) {
+			properties[name] = property
+		}
+	}
+	for name, property := range schema.Properties {
+		properties[name] = property
+	}
+	return properties
+}
+
+// jsonFields returns the types of the fields written by encoding/json for the struct,
+// by their JSON name, with the fields of untagged embedded structs promoted.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for promoted, fieldType := range jsonFields(field.Type) {
+				if _, ok := fields[promoted]; !ok {
+					fields[promoted] = fieldType
+				}
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
@@ -0,0 +1,9 @@
+package main
+
+import "testing"
+
+func TestOpenAPIMatchesTheApi(t *testing.T) {
+	for _, problem := range checkOpenAPI(newRouter()) {
+		t.Error(problem)
+	}
+}
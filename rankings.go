@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	routing "github.com/go-ozzo/ozzo-routing"
+
+	"bob-leaderboard/app"
+	"bob-leaderboard/app/logger"
+	"bob-leaderboard/db"
+)
+
+// rankingCache is the materialised ranking served with Rankings.Materialised, nil otherwise.
+var rankingCache *db.CachedRepository
+
+// RankingRebuild reports a rebuild of the materialised ranking.
+type RankingRebuild struct {
+	Results    int     `json:"results"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+// rebuildRankings ranks every result of the cache from scratch.
+func rebuildRankings(ctx context.Context, cache *db.CachedRepository) (RankingRebuild, error) {
+	start := time.Now()
+	results, err := cache.Rebuild(ctx)
+	rebuild := RankingRebuild{results, float64(time.Since(start).Microseconds()) / 1000}
+	if err != nil {
+		return rebuild, err
+	}
+
+	logger.Info("Ranked %d results in %.0fms", rebuild.Results, rebuild.DurationMs)
+	return rebuild, nil
+}
+
+// rankingRebuildRequests asks runRankingCache for a rebuild before the next interval.
+var rankingRebuildRequests = make(chan struct{}, 1)
+
+// requestRankingRebuild asks for a rebuild of the materialised ranking, after stored
+// results were dropped on their way to it. The requests made before it starts are merged.
+func requestRankingRebuild() {
+	select {
+	case rankingRebuildRequests <- struct{}{}:
+	default:
+	}
+}
+
+// runRankingCache keeps the materialised ranking in line with the other instances: the
+// results they store are ranked by the live feed with Live.ChangeStream, the rest is
+// picked up by rebuilding every Rankings.RebuildInterval, or as soon as the live feed
+// dropped results. The results ranked during a rebuild are journaled by the cache and
+// applied over it.
+func runRankingCache(ctx context.Context) {
+	var interval <-chan time.Time
+	if every := app.Settings.Rankings.RebuildInterval.Duration; every > 0 {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		interval = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-interval:
+		case <-rankingRebuildRequests:
+			logger.Warning("Rebuilding the materialised ranking, the live feed dropped stored results")
+		}
+		if _, err := rebuildRankings(ctx, rankingCache); err != nil && ctx.Err() == nil {
+			logger.Error("Error rebuilding the materialised ranking: %v", err)
+		}
+	}
+}
+
+// RebuildRankingsEndpoint ranks every result from scratch, after the results were
+// changed outside of the api, e.g. by recompute-ranks.
+func RebuildRankingsEndpoint(c *routing.Context) error {
+	if rankingCache == nil {
+		return NewAPIError(http.StatusNotFound, CodeNotFound, "the materialised ranking is disabled")
+	}
+
+	rebuild, err := rebuildRankings(c.Request.Context(), rankingCache)
+	if err != nil {
+		requestLogger(c, "admin/rankings/rebuild").Error("Error rebuilding the materialised ranking", "error", err)
+		return err
+	}
+	return c.Write(rebuild)
+}
+
+func rankingsRebuildCommand(args []string) error {
+	flags := flag.NewFlagSet("rankings rebuild", flag.ContinueOnError)
+	sample := flags.Int("sample", 0, "check the rankings of this many random results against the ranking aggregation")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	cache := db.NewCachedRepository(db.NewMongoRepository(), db.LoadRankedResults)
+	rebuild, err := rebuildRankings(ctx, cache)
+	if err != nil {
+		return err
+	}
+	if *sample <= 0 || rebuild.Results == 0 {
+		return nil
+	}
+
+	mismatches := 0
+	for i := 0; i < *sample; i++ {
+		entry := cache.Index().Entries(rand.Intn(rebuild.Results), 1)[0]
+		ranking, err := db.GetRankingForGame(ctx, entry.GameId)
+		if err != nil {
+			return err
+		}
+		if ranking != entry.Ranking {
+			mismatches++
+			logger.Error("Result %s is ranked %d by the aggregation and %d by the materialised ranking", entry.GameId.Hex(), ranking, entry.Ranking)
+		}
+	}
+	if mismatches > 0 {
+		return fmt.Errorf("%d sampled result(s) are ranked differently", mismatches)
+	}
+
+	logger.Info("The sampled rankings match the ranking aggregation")
+	return nil
+}
+
+// logRankingRebuildHint reminds to rebuild the materialised ranking of the running servers,
+// after a command changed the stored results behind their back.
+func logRankingRebuildHint() {
+	if app.Settings.Rankings.Materialised {
+		logger.Info("Rebuild the materialised ranking of the running servers with POST /api/admin/rankings/rebuild")
+	}
+}
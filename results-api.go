@@ -1,66 +1,328 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
 	routing "github.com/go-ozzo/ozzo-routing"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"bob-leaderboard/app/logger"
+	"bob-leaderboard/app/metrics"
 	"bob-leaderboard/db"
 )
 
+// leaderboard is the repository used by the api handlers, set up by main.
+var leaderboard db.LeaderboardRepository
+
+// leaderboards are the boards served by /api/v1/leaderboards/<board>.
+var leaderboards = map[string]bool{
+	"global": true,
+}
+
+// requestLogger returns the logger of the request, with the route being handled.
+func requestLogger(c *routing.Context, route string) *logger.FieldLogger {
+	return logger.FromContext(c.Request.Context()).With("route", route)
+}
+
+const (
+	// idempotencyKeyHeader identifies a submission, like the runId of its body which it
+	// takes precedence over. Repeated submissions are answered with the result stored first.
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader is set on the answers to repeated submissions.
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+)
+
+// SubmittedResult is the response to a game result submission.
+type SubmittedResult struct {
+	EntryId primitive.ObjectID `json:"entryId"`
+	Ranking int                `json:"ranking"`
+	// Replayed is set when the result was stored by an earlier submission
+	Replayed bool `json:"-"`
+}
+
+// PutResultEndpoint is the deprecated alias of PostResultEndpoint, it answers 200 OK
+// as shipped game builds expect.
 func PutResultEndpoint(c *routing.Context) error {
-	var data db.GameResultRequestData
+	result, err := submitResult(c, requestLogger(c, "rankings/game-result"))
+	if err != nil {
+		return err
+	}
+	return c.Write(result)
+}
 
-	if err := c.Read(&data); err != nil {
-		return err // Handle error appropriately
+// PostResultEndpoint stores a game result and answers 201 Created with its ranking. A
+// repeated submission creates nothing and is answered 200 OK with the stored result.
+func PostResultEndpoint(c *routing.Context) error {
+	result, err := submitResult(c, requestLogger(c, "v1/results"))
+	if err != nil {
+		return err
+	}
+	if result.Replayed {
+		return c.Write(result)
 	}
 
-	logger.Debug("Steam Auth Ticket: %s", c.Request.Header.Get("Steam-Auth-Ticket"))
+	c.Response.Header().Set("Location", "/api/v1/results/"+result.EntryId.Hex())
+	c.Response.WriteHeader(http.StatusCreated)
+	return c.Write(result)
+}
+
+func submitResult(c *routing.Context, log *logger.FieldLogger) (SubmittedResult, error) {
+	var data db.GameResultRequestData
 
-	if data.Player.SteamId == "" || data.Player.Name == "" {
-		return routing.NewHTTPError(400, "steamId and steamName are required")
+	if err := readBody(c, &data); err != nil {
+		metrics.Submissions.WithLabelValues("rejected", "invalid_body").Inc()
+		log.Debug("Rejected game result", "reason", "invalid_body", "error", err)
+		return SubmittedResult{}, err
 	}
 
+	log = log.With("steamId", data.Player.SteamId)
+	log.Debug("Steam auth ticket", "present", c.Request.Header.Get("Steam-Auth-Ticket") != "")
+
 	gameResult := db.NewGameResult(data)
 
-	if len(gameResult.WaveTimes) <= 0 {
-		return routing.NewHTTPError(400, "waveDurations are required")
+	reasons, details := validateSubmission(data, gameResult)
+	if key := c.Request.Header.Get(idempotencyKeyHeader); key != "" {
+		gameResult.IdempotencyKey = key
+		if len(key) > maxIdempotencyKeyLength {
+			reasons = append(reasons, "invalid_idempotency_key")
+			details = append(details, FieldError{idempotencyKeyHeader, FieldInvalidValue, fmt.Sprintf("must be at most %d characters", maxIdempotencyKeyLength)})
+		}
+	}
+	if len(details) > 0 {
+		// A submission is counted once, under the reason of its first problem
+		metrics.Submissions.WithLabelValues("rejected", reasons[0]).Inc()
+		log.Debug("Rejected game result", "reason", reasons[0], "problems", len(details))
+		return SubmittedResult{}, NewValidationError(details)
+	}
+
+	if gameResult.IdempotencyKey != "" {
+		result, err := replaySubmission(c, log, gameResult)
+		if !errors.Is(err, db.ErrGameNotFound) {
+			return result, err
+		}
+	}
+
+	banned, err := leaderboard.IsBanned(c.Request.Context(), data.Player.SteamId)
+	if err != nil {
+		return SubmittedResult{}, err
+	}
+	if banned {
+		metrics.Submissions.WithLabelValues("rejected", "banned").Inc()
+		log.Info("Rejected game result", "reason", "banned")
+		return SubmittedResult{}, NewAPIError(http.StatusForbidden, CodePlayerBanned, "player is banned")
 	}
-	if gameResult.WavesSurvived <= 0 {
-		return routing.NewHTTPError(400, "wavesSurvived must be greater than 0")
+
+	entryId, err := leaderboard.InsertResult(c.Request.Context(), gameResult)
+	if errors.Is(err, db.ErrDuplicateSubmission) {
+		// A retry was stored while this submission was checked
+		return replaySubmission(c, log, gameResult)
 	}
-	if gameResult.TotalGameTime <= 0 {
-		return routing.NewHTTPError(400, "totalGameTime must be greater than 0")
+	if err != nil {
+		metrics.Submissions.WithLabelValues("rejected", "storage_error").Inc()
+		log.Error("Error storing game result", "error", err)
+		return SubmittedResult{}, err
 	}
+	metrics.Submissions.WithLabelValues("accepted", "").Inc()
+	publishStoredResult(*gameResult)
 
-	collection := db.GetCollection[db.GameResult]()
+	log = log.With("gameId", entryId.Hex())
+	log.Info("Accepted game result", "wavesSurvived", gameResult.WavesSurvived, "totalGameTime", gameResult.TotalGameTime)
 
-	insertResult, err := collection.InsertOne(gameResult)
+	gameRanking, err := leaderboard.GetRankingForGame(c.Request.Context(), entryId)
 	if err != nil {
-		return err
+		log.Error("Error ranking game result", "error", err)
+		return SubmittedResult{}, err
 	}
 
-	gameRanking, err := db.GetRankingForGame(insertResult.InsertedID.(primitive.ObjectID))
+	return SubmittedResult{EntryId: entryId, Ranking: gameRanking}, nil
+}
+
+// replaySubmission answers a submission repeating the idempotency key of a stored result
+// with that result and its current ranking, or returns ErrGameNotFound when there is none.
+func replaySubmission(c *routing.Context, log *logger.FieldLogger, gameResult *db.GameResult) (SubmittedResult, error) {
+	stored, err := leaderboard.FindResultBySubmission(c.Request.Context(), gameResult.Player.SteamId, gameResult.IdempotencyKey)
 	if err != nil {
-		return err
+		if !errors.Is(err, db.ErrGameNotFound) {
+			log.Error("Error finding the submission", "error", err)
+		}
+		return SubmittedResult{}, err
 	}
 
-	return c.Write(map[string]interface{}{
-		"entryId": insertResult.InsertedID,
-		"ranking": gameRanking,
-	})
+	log = log.With("gameId", stored.ID.Hex())
+	gameRanking, err := leaderboard.GetRankingForGame(c.Request.Context(), stored.ID)
+	if err != nil {
+		log.Error("Error ranking game result", "error", err)
+		return SubmittedResult{}, err
+	}
+
+	metrics.Submissions.WithLabelValues("replayed", "").Inc()
+	log.Info("Replayed game result")
+	c.Response.Header().Set(idempotentReplayedHeader, "true")
+	return SubmittedResult{EntryId: stored.ID, Ranking: gameRanking, Replayed: true}, nil
 }
 
+// validateSubmission returns every problem of a submission, with the metric reason of each.
+func validateSubmission(data db.GameResultRequestData, gameResult *db.GameResult) ([]string, []FieldError) {
+	var reasons []string
+	var details []FieldError
+	add := func(reason string, detail FieldError) {
+		reasons = append(reasons, reason)
+		details = append(details, detail)
+	}
+
+	if data.Player.SteamId == "" {
+		add("missing_player", FieldError{"player.steamId", FieldRequired, "is required"})
+	}
+	if data.Player.Name == "" {
+		add("missing_player", FieldError{"player.steamName", FieldRequired, "is required"})
+	}
+
+	if len(data.RunId) > maxIdempotencyKeyLength {
+		add("invalid_idempotency_key", FieldError{"runId", FieldInvalidValue, fmt.Sprintf("must be at most %d characters", maxIdempotencyKeyLength)})
+	}
+
+	switch {
+	case len(data.Waves) == 0:
+		add("missing_waves", FieldError{"waveDurations", FieldRequired, "is required"})
+	case gameResult.WavesSurvived <= 0:
+		add("no_waves_survived", FieldError{"waveDurations", FieldInvalidValue, "must contain at least one survived wave, with a duration greater than 0"})
+	}
+
+	return reasons, details
+}
+
+// GetRankings is the deprecated alias of GetLeaderboardEntries, reading the options from a JSON body.
 func GetRankings(c *routing.Context) error {
 	var options db.GetRankingsOptions
-	if err := c.Read(&options); err != nil {
+	if err := readBody(c, &options); err != nil {
 		return err
 	}
+	if details := checkFilters(options, "filters."); len(details) > 0 {
+		return NewValidationError(details)
+	}
+
+	return writeRankings(c, requestLogger(c, "rankings"), options)
+}
+
+// GetLeaderboardEntries returns a page of a leaderboard, reading the options from the
+// query string: page or cursor, size, sort (asc or desc) and the steamName, steamId and gameId filters.
+func GetLeaderboardEntries(c *routing.Context) error {
+	board := c.Param("board")
+	if !leaderboards[board] {
+		return NewAPIError(http.StatusNotFound, CodeNotFound, fmt.Sprintf("unknown leaderboard %q", board))
+	}
 
-	results, err := db.GetAllRankingsPaginated(options.Validate())
+	options, err := rankingsQueryOptions(c)
 	if err != nil {
 		return err
 	}
 
+	log := requestLogger(c, "v1/leaderboards/entries").With("board", board)
+	if leaderboardNotModified(c, log, "entries") {
+		return nil
+	}
+	return writeRankings(c, log, options)
+}
+
+// rankingsQueryOptions reads the options from the query string, reporting every invalid parameter.
+func rankingsQueryOptions(c *routing.Context) (db.GetRankingsOptions, error) {
+	query := c.Request.URL.Query()
+	options := db.GetRankingsOptions{Filters: map[string]any{}}
+	var details []FieldError
+
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{{"page", &options.Page}, {"size", &options.Size}} {
+		if query.Get(param.name) == "" {
+			continue
+		}
+		number, err := strconv.Atoi(query.Get(param.name))
+		if err != nil || number < 1 {
+			details = append(details, FieldError{param.name, FieldInvalidValue, "must be a positive integer"})
+			continue
+		}
+		*param.value = number
+	}
+
+	switch sort := query.Get("sort"); sort {
+	case "", "asc", "desc":
+		options.SortDirection = sort
+	default:
+		details = append(details, FieldError{"sort", FieldInvalidValue, "must be asc or desc"})
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		if _, err := db.ParseRankingCursor(cursor); err != nil {
+			details = append(details, FieldError{"cursor", FieldInvalidValue, "must be a cursor returned with a page"})
+		}
+		options.Cursor = cursor
+	}
+
+	for _, filter := range []string{"steamName", "steamId", "gameId"} {
+		if value := query.Get(filter); value != "" {
+			options.Filters[filter] = value
+		}
+	}
+	details = append(details, checkFilters(options, "")...)
+
+	if len(details) > 0 {
+		return options, NewValidationError(details)
+	}
+	return options, nil
+}
+
+func writeRankings(c *routing.Context, log *logger.FieldLogger, options db.GetRankingsOptions) error {
+	if steamId, ok := options.Filters["steamId"]; ok {
+		log = log.With("steamId", steamId)
+	}
+	if gameId, ok := options.Filters["gameId"]; ok {
+		log = log.With("gameId", gameId)
+	}
+
+	results, err := leaderboard.GetRankingsPage(c.Request.Context(), options)
+	if err != nil {
+		log.Error("Error loading rankings", "error", err)
+		return err
+	}
+	log.Debug("Loaded rankings", "page", options.Page, "size", options.Size, "total", results.Pagination.Total)
+
 	return c.Write(results)
 }
+
+// GetResultEndpoint returns a single game result. Results hidden by a ban are not found.
+func GetResultEndpoint(c *routing.Context) error {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return NewValidationError([]FieldError{{"id", FieldInvalidValue, "must be a 24 character hex id"}})
+	}
+
+	log := requestLogger(c, "v1/results").With("gameId", id.Hex())
+	if leaderboardNotModified(c, log, "result") {
+		return nil
+	}
+
+	result, err := leaderboard.FindResultByID(c.Request.Context(), id)
+	if errors.Is(err, db.ErrGameNotFound) || (err == nil && result.Hidden) {
+		return NewAPIError(http.StatusNotFound, CodeNotFound, "result not found")
+	}
+	if err != nil {
+		log.Error("Error loading game result", "error", err)
+		return err
+	}
+
+	return c.Write(result)
+}
+
+// Deprecated marks a route as replaced by successor, following the Deprecation header draft.
+func Deprecated(successor string) routing.Handler {
+	return func(c *routing.Context) error {
+		c.Response.Header().Set("Deprecation", "true")
+		c.Response.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", successor))
+		return nil
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"bob-leaderboard/app"
+	"bob-leaderboard/db"
+)
+
+// useMemoryLeaderboard serves the api from a memory repository holding the results,
+// restoring the leaderboard and the settings when the test ends.
+func useMemoryLeaderboard(t *testing.T, results ...db.GameResultRequestData) (*db.MemoryRepository, *httptest.Server) {
+	t.Helper()
+	repository := db.NewMemoryRepository()
+	for _, data := range results {
+		if _, err := repository.InsertResult(context.Background(), db.NewGameResult(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previous, settings := leaderboard, app.Settings
+	leaderboard = repository
+	app.Settings.Api.Secret = "test-secret"
+	server := httptest.NewServer(newRouter())
+	t.Cleanup(func() {
+		server.Close()
+		leaderboard, app.Settings = previous, settings
+	})
+	return repository, server
+}
+
+// apiRequest sends a request with a JSON body to the api and decodes its JSON answer.
+func apiRequest(t *testing.T, method, url string, body any, header http.Header, answer any) *http.Response {
+	t.Helper()
+	var encoded bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&encoded).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	request, err := http.NewRequest(method, url, &encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.Header.Set("Content-Type", "application/json")
+	// ozzo-routing reads the bearer token base64 encoded
+	request.Header.Set("Authorization", "Bearer "+base64.StdEncoding.EncodeToString([]byte(app.Settings.Api.Secret)))
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if answer != nil {
+		if err := json.NewDecoder(response.Body).Decode(answer); err != nil {
+			t.Fatalf("%s %s: decoding the answer: %v", method, url, err)
+		}
+	}
+	return response
+}
+
+func TestExtraStatsKeys(t *testing.T) {
+	repository, server := useMemoryLeaderboard(t, db.GameResultRequestData{
+		ExtraGameStatsData: db.ExtraGameStatsData{DamageDealt: 1520.5, EnemiesKilled: 42},
+		Player:             db.SteamUserData{SteamId: "76561198000000001", Name: "Ada"},
+		Waves:              []float64{30.5, 28},
+	})
+
+	// The deprecated rankings keep the stats at the top level of the entries
+	var rankings struct {
+		Data []map[string]any `json:"data"`
+	}
+	apiRequest(t, http.MethodPost, server.URL+"/api/rankings", db.GetRankingsOptions{}, nil, &rankings)
+	if len(rankings.Data) != 1 {
+		t.Fatalf("/api/rankings returned %d entries, want 1", len(rankings.Data))
+	}
+	if entry := rankings.Data[0]; entry["damageDealt"] != 1520.5 || entry["enemiesKilled"] != 42.0 || entry["extra"] != nil {
+		t.Errorf("/api/rankings entry = %v, want the stats at the top level", entry)
+	}
+
+	// The v1 results hold them under extra
+	results, err := repository.Results(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result map[string]any
+	apiRequest(t, http.MethodGet, server.URL+"/api/v1/results/"+results[0].ID.Hex(), nil, nil, &result)
+	if extra, ok := result["extra"].(map[string]any); !ok || extra["damageDealt"] != 1520.5 {
+		t.Errorf("/api/v1/results entry = %v, want the stats under extra", result)
+	}
+}
+
+func TestSubmissionIdempotency(t *testing.T) {
+	repository, server := useMemoryLeaderboard(t)
+	url := server.URL + "/api/v1/results"
+	player := db.SteamUserData{SteamId: "76561198000000001", Name: "Ada"}
+	submission := func(runId string, waves ...float64) db.GameResultRequestData {
+		return db.GameResultRequestData{Player: player, Waves: waves, RunId: runId}
+	}
+	keyHeader := func(key string) http.Header {
+		return http.Header{"Idempotency-Key": {key}}
+	}
+
+	type submitted struct {
+		SubmittedResult
+		replayed bool
+	}
+	submit := func(data db.GameResultRequestData, header http.Header, status int) submitted {
+		t.Helper()
+		var result SubmittedResult
+		response := apiRequest(t, http.MethodPost, url, data, header, &result)
+		if response.StatusCode != status {
+			t.Fatalf("submission answered %d, want %d", response.StatusCode, status)
+		}
+		replayed := response.Header.Get("Idempotent-Replayed") == "true"
+		location := response.Header.Get("Location")
+		switch {
+		case replayed && location != "":
+			t.Errorf("a replay answered with the Location %s", location)
+		case !replayed && location != "/api/v1/results/"+result.EntryId.Hex():
+			t.Errorf("a new result answered with the Location %q", location)
+		}
+		return submitted{result, replayed}
+	}
+
+	// The header takes precedence over the runId of the body
+	first := submit(submission("run-b", 30, 30, 30), keyHeader("key-a"), http.StatusCreated)
+	if first.replayed || first.Ranking != 0 {
+		t.Fatalf("first submission = %+v, want a new result ranked 0", first)
+	}
+
+	// A retry with the key in the body replays the stored result, whatever it holds now
+	retry := submit(submission("key-a", 12), nil, http.StatusOK)
+	if !retry.replayed || retry.EntryId != first.EntryId || retry.Ranking != first.Ranking {
+		t.Errorf("retry = %+v, want the replay of %+v", retry, first.SubmittedResult)
+	}
+
+	// The runId overridden by the header was not used, it stores a new result
+	second := submit(submission("run-b", 30), nil, http.StatusCreated)
+	if second.replayed || second.EntryId == first.EntryId || second.Ranking != 1 {
+		t.Fatalf("second submission = %+v, want a new result ranked 1", second)
+	}
+
+	// A header conflicting with the body wins
+	conflicting := submit(submission("key-a", 30, 30, 30, 30), keyHeader("run-b"), http.StatusOK)
+	if !conflicting.replayed || conflicting.EntryId != second.EntryId || conflicting.Ranking != 1 {
+		t.Errorf("conflicting submission = %+v, want the replay of %+v", conflicting, second.SubmittedResult)
+	}
+
+	// The deprecated route answers 200 OK either way
+	var replayed SubmittedResult
+	response := apiRequest(t, http.MethodPost, server.URL+"/api/rankings/game-result", submission("key-a", 30), nil, &replayed)
+	if response.StatusCode != http.StatusOK || response.Header.Get("Idempotent-Replayed") != "true" || replayed.EntryId != first.EntryId {
+		t.Errorf("deprecated replay answered %d %+v, want 200 with %s", response.StatusCode, replayed, first.EntryId.Hex())
+	}
+
+	results, err := repository.Results(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Errorf("stored %d results, want 2", len(results))
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"bob-leaderboard/app"
+	"bob-leaderboard/app/logger"
+	"bob-leaderboard/app/metrics"
+	"bob-leaderboard/db"
+)
+
+// serveCommand prepares the database and runs the web server.
+func serveCommand(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if app.Settings.Mongo.Indexes.SyncOnStartup {
+		err := syncIndexes(db.IndexSyncOptions{DropStale: app.Settings.Mongo.Indexes.DropStale})
+		if err != nil {
+			return fmt.Errorf("syncing indexes: %w", err)
+		}
+	}
+
+	if app.Settings.Migrations.RunOnStartup {
+		if err := runMigrations(false); err != nil {
+			return fmt.Errorf("running migrations: %w", err)
+		}
+	}
+
+	leaderboard = db.NewMongoRepository()
+	if app.Settings.Rankings.Materialised {
+		rankingCache = db.NewCachedRepository(leaderboard, db.LoadRankedResults)
+		if _, err := rebuildRankings(context.Background(), rankingCache); err != nil {
+			return fmt.Errorf("building the materialised ranking: %w", err)
+		}
+		leaderboard = rankingCache
+		app.RunInBackground("ranking cache", runRankingCache)
+	}
+
+	app.RunInBackground("live feed", runLiveFeed)
+	if app.Settings.Live.ChangeStream {
+		app.RunInBackground("results change stream", runResultStream)
+	}
+
+	return serve(newRouter())
+}
+
+// serve runs the handler until SIGINT or SIGTERM is received, then drains in-flight
+// requests, stops the background workers and closes the database and logger.
+func serve(handler http.Handler) error {
+	settings := app.Settings.Api
+	server := &http.Server{
+		Addr:              settings.ListenAddr,
+		Handler:           handler,
+		ReadTimeout:       settings.ReadTimeout.Duration,
+		ReadHeaderTimeout: settings.ReadHeaderTimeout.Duration,
+		WriteTimeout:      settings.WriteTimeout.Duration,
+		IdleTimeout:       settings.IdleTimeout.Duration,
+	}
+	// Shutdown doesn't wait for the live streams, closing their subscriptions ends them
+	server.RegisterOnShutdown(liveUpdates.Close)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if app.Settings.Metrics.Enabled {
+		metricsAddr := app.Settings.Metrics.ListenAddr
+		if metricsAddr == server.Addr {
+			return errors.New("Metrics.ListenAddr must differ from Api.ListenAddr to keep /metrics internal")
+		}
+		app.RunInBackground("metrics", func(ctx context.Context) {
+			metrics.Serve(ctx, metricsAddr)
+		})
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Debug("Listening on: http://localhost%s", server.Addr)
+		serveErr <- server.ListenAndServe()
+	}()
+
+	var err error
+	select {
+	case err = <-serveErr:
+		// The server failed to start (e.g. the address is in use), still clean up below
+	case <-ctx.Done():
+		logger.Info("Shutting down, draining in-flight requests")
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), settings.ShutdownTimeout.Duration)
+	defer cancel()
+
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil {
+		logger.Error("Error draining requests: %v", shutdownErr)
+	}
+	if stopErr := app.StopBackground(shutdownCtx); stopErr != nil {
+		logger.Error("Error stopping background workers: %v", stopErr)
+	}
+	if disconnectErr := db.Disconnect(shutdownCtx); disconnectErr != nil {
+		logger.Error("Error disconnecting from database: %v", disconnectErr)
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	if err == nil {
+		logger.Info("Shutdown complete")
+	}
+
+	return err
+}
+
+func exit(code int) {
+	logger.Logger.Close()
+	os.Exit(code)
+}